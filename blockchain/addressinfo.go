@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/dgraph-io/badger"
+)
+
+// a summary of each address for explorers and analytics, kept by connecting blocks so it is read with one lookup
+// instead of a walk over the address index, disconnecting a block puts back the summaries it changed
+type AddressInfo struct {
+	FirstSeen    int // the height of the first main chain block with a transaction touching the address
+	LastActive   int // the height of the latest such block
+	Received     int // every token paid to the address, change included
+	Sent         int // every token spent from the address
+	Transactions int // the number of transactions touching the address
+}
+
+// address info index: public key hash -> the address's summary
+var AddressInfoPrefix = []byte("ainfo-")
+
+// the summary of an address before a block changed it, nil if the block was the first to touch it
+type addressInfoUndo struct {
+	PublicKeyHash []byte
+	Previous      *AddressInfo
+}
+
+func addressInfoKey(publicKeyHash []byte) []byte {
+	return append(slicesCopy(AddressInfoPrefix), publicKeyHash...)
+}
+
+func getAddressInfo(txn *badger.Txn, publicKeyHash []byte) (*AddressInfo, error) {
+	data, err := getValue(txn, addressInfoKey(publicKeyHash))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var info AddressInfo
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// add a transaction of the block at the given height to the summaries of the addresses it touches
+// spent holds the outputs the transaction's inputs spent
+func indexAddressInfo(txn *badger.Txn, tx *Transaction, height int, spent []spentOutput, undo *blockUndo) error {
+	received := make(map[string]int)
+	for _, out := range tx.Outputs {
+		if !out.IsData() {
+			received[string(out.PublicKeyHash)] += out.Value
+		}
+	}
+
+	addresses := touchedAddresses(tx)
+	sent := make(map[string]int)
+	for _, s := range spent {
+		publicKeyHash := s.Output.PublicKeyHash
+		if _, ok := sent[string(publicKeyHash)]; !ok && !containsHash(addresses, publicKeyHash) {
+			addresses = append(addresses, publicKeyHash)
+		}
+		sent[string(publicKeyHash)] += s.Output.Value
+	}
+
+	for _, publicKeyHash := range addresses {
+		current, err := getAddressInfo(txn, publicKeyHash)
+		if err != nil {
+			return err
+		}
+		undo.Addresses = append(undo.Addresses, addressInfoUndo{publicKeyHash, current})
+
+		info := AddressInfo{FirstSeen: height}
+		if current != nil {
+			info = *current
+		}
+		info.LastActive = height
+		info.Received += received[string(publicKeyHash)]
+		info.Sent += sent[string(publicKeyHash)]
+		info.Transactions++
+
+		if err := txn.Set(addressInfoKey(publicKeyHash), encodeGob(info)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// put back the summaries a disconnected block changed, the latest change first
+func unindexAddressInfo(txn *badger.Txn, addresses []addressInfoUndo) error {
+	for i := len(addresses) - 1; i >= 0; i-- {
+		var err error
+		if addresses[i].Previous == nil {
+			err = txn.Delete(addressInfoKey(addresses[i].PublicKeyHash))
+		} else {
+			err = txn.Set(addressInfoKey(addresses[i].PublicKeyHash), encodeGob(addresses[i].Previous))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsHash(hashes [][]byte, hash []byte) bool {
+	for _, h := range hashes {
+		if bytes.Equal(h, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// the summary of an address on the main chain, false if no transaction has touched it
+func (chain *BlockChain) AddressInfo(publicKeyHash []byte) (AddressInfo, bool) {
+	var info *AddressInfo
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		info, err = getAddressInfo(txn, publicKeyHash)
+		return err
+	})
+	Handle(err)
+
+	if info == nil {
+		return AddressInfo{}, false
+	}
+	return *info, true
+}
@@ -0,0 +1,170 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"io"
+	"os"
+	"time"
+)
+
+// anchoring embeds the hash of a document in a data output, the block including it then proves the document
+// existed at the block's time: nobody could have hashed it before it was written, nor slipped it into an
+// older block without redoing that block's proof-of-work and every block's on top of it
+const AnchorFee = 1 // left to the miner, an anchor needs an input to be signed like any other transaction
+
+// a self-contained proof that a hash was anchored in a block
+type AnchorProof struct {
+	ChainID     string
+	Hash        []byte
+	Transaction Transaction   // the transaction holding the anchor output
+	Output      int           // the index of the anchor output
+	MerklePath  []MerkleStep  // proves the transaction is part of the first header's block
+	Headers     []BlockHeader // the block including the transaction, followed by every block up to the tip at proving time
+}
+
+// hash a file's contents the way anchors expect
+func HashFile(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return nil, err
+	}
+
+	return hasher.Sum(nil), nil
+}
+
+// create a transaction anchoring a hash
+func NewAnchorTransaction(w *wallet.Wallet, hash []byte, UTXO *UTXOSet) (*Transaction, error) {
+	if len(hash) != sha256.Size {
+		return nil, fmt.Errorf("anchors hold %d byte hashes, not %d bytes", sha256.Size, len(hash))
+	}
+
+	outputs := []TransactionOutput{*NewDataOutput(0, DataAnchor, hash)}
+
+	return newSpendingTransaction(w, 0, AnchorFee, outputs, UTXO), nil
+}
+
+func (p *AnchorProof) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	encode := gob.NewEncoder(&buffer)
+	err := encode.Encode(p)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+func DeserializeAnchorProof(data []byte) (*AnchorProof, error) {
+	var proof AnchorProof
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	if err := decode.Decode(&proof); err != nil {
+		return nil, err
+	}
+
+	return &proof, nil
+}
+
+// build the proof for an anchor transaction, the headers run up to the current tip
+// the deeper the anchor is buried, the more work it takes to forge its proof
+func (chain *BlockChain) NewAnchorProof(txID []byte) (*AnchorProof, error) {
+	block, txIdx, above, err := chain.locateTransaction(txID)
+	if err != nil {
+		return nil, err
+	}
+	tx := block.Transactions[txIdx]
+
+	for outIdx, out := range tx.Outputs {
+		kind, hash := out.payload()
+		if kind != DataAnchor {
+			continue
+		}
+
+		headers := []BlockHeader{block.Header()}
+		for _, confirming := range above {
+			headers = append(headers, confirming.Header())
+		}
+
+		proof := AnchorProof{chain.ChainID, hash, *tx, outIdx, block.MerkleProof(txIdx), headers}
+		return &proof, nil
+	}
+
+	return nil, errors.New("transaction holds no anchor")
+}
+
+// check a proof on its own and return the time the block anchoring the hash claims
+// the proof only shows that work was done on top of the anchor, the proof-of-work covers neither the headers'
+// timestamps nor their heights, so the time is only established once the headers are found on a chain one
+// trusts, see CheckAnchorProof
+func (p *AnchorProof) Verify(hash []byte) (time.Time, error) {
+	if len(p.Headers) == 0 {
+		return time.Time{}, errors.New("proof holds no headers")
+	}
+
+	if err := CheckHeaderChain(p.Headers, ParamsFor(p.ChainID).Difficulty); err != nil {
+		return time.Time{}, err
+	}
+
+	if !VerifyMerkleProof(p.Transaction.Serialize(), p.MerklePath, p.Headers[0].MerkleRoot) {
+		return time.Time{}, errors.New("transaction is not part of the proven block")
+	}
+
+	if p.Output < 0 || p.Output >= len(p.Transaction.Outputs) {
+		return time.Time{}, errors.New("proof points at a missing output")
+	}
+
+	kind, anchored := p.Transaction.Outputs[p.Output].payload()
+	if kind != DataAnchor {
+		return time.Time{}, errors.New("proof points at an output that is not an anchor")
+	}
+	if !bytes.Equal(anchored, p.Hash) || !bytes.Equal(anchored, hash) {
+		return time.Time{}, errors.New("proof anchors a different hash")
+	}
+
+	return time.Unix(p.Headers[0].Timestamp, 0), nil
+}
+
+// check a proof against this chain: on top of holding by itself, every one of its headers must be the header of
+// the main chain block at its height, field for field, so the time returned is the anchoring block's own
+func (chain *BlockChain) CheckAnchorProof(p *AnchorProof, hash []byte) (time.Time, error) {
+	anchoredAt, err := p.Verify(hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if p.ChainID != chain.ChainID {
+		return time.Time{}, fmt.Errorf("proof comes from chain %s, not %s", p.ChainID, chain.ChainID)
+	}
+
+	for _, header := range p.Headers {
+		mainHash, err := chain.GetBlockHashAt(header.Height)
+		if err != nil || !bytes.Equal(mainHash, header.Hash) {
+			return time.Time{}, fmt.Errorf("block %x is not part of the main chain", header.Hash)
+		}
+
+		block, err := chain.GetBlock(mainHash)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !sameHeader(block.Header(), header) {
+			return time.Time{}, fmt.Errorf("the proof's header of block %x differs from the stored block", header.Hash)
+		}
+	}
+
+	return anchoredAt, nil
+}
+
+func sameHeader(a, b BlockHeader) bool {
+	return a.Timestamp == b.Timestamp && bytes.Equal(a.Hash, b.Hash) && bytes.Equal(a.PrevHash, b.PrevHash) &&
+		bytes.Equal(a.MerkleRoot, b.MerkleRoot) && a.Nonce == b.Nonce && a.Height == b.Height
+}
@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	maxPendingWrites = 256 // the number of pending writes badger is allowed to buffer while loading a backup
+
+	backupDirPath = "./tmp/backups_%s"
+	restorePath   = "./tmp/restore_%s"
+)
+
+var backupNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// stream a consistent snapshot of the chain database into the writer
+// badger's backup runs inside a read transaction, so it is safe to call while the node keeps writing blocks
+func (chain *BlockChain) Backup(w io.Writer) error {
+	_, err := chain.Database.Backup(w, 0)
+	return err
+}
+
+// write a snapshot of the chain database to the given file
+func (chain *BlockChain) BackupToFile(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := chain.Backup(f); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// recreate a node's blockchain from a backup stream produced by Backup()
+// the stream is loaded into a staging directory first, the chain it holds is only known once it is read, and
+// the staging directory is moved to the chain's own path at the end, a failed restore leaves nothing behind
+func RestoreBlockChain(nodeId string, r io.Reader) (*BlockChain, error) {
+	staging := fmt.Sprintf(restorePath, nodeId)
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+
+	chainID, err := loadBackup(staging, r)
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+
+	path := ChainPath(chainID, nodeId)
+	if DBexists(path) {
+		os.RemoveAll(staging)
+		return nil, errChainExists
+	}
+	// a directory left without a database, such as an empty one, is replaced
+	os.Remove(path)
+	if err := os.Rename(staging, path); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+
+	return openBlockChain(chainID, path)
+}
+
+// load a backup stream into a new database at path, returning the ID of the chain it holds
+func loadBackup(path string, r io.Reader) (string, error) {
+	opts := badger.DefaultOptions(path)
+	opts.ValueDir = path
+	db, err := openDB(path, opts)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	if err := db.Load(r, maxPendingWrites); err != nil {
+		return "", err
+	}
+
+	// a backup without a last hash pointer did not come from a blockchain database
+	err = db.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte("lh")); err != nil {
+			return errors.New("Backup does not contain a blockchain")
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return readChainID(db)
+}
+
+// the path of a backup file in the node's backup directory, creating the directory if needed
+// backups are only written there, under a plain file name, so a request cannot pick any other file
+func BackupPath(name, nodeId string) (string, error) {
+	if !backupNamePattern.MatchString(name) {
+		return "", fmt.Errorf("backup name %q is not a plain file name", name)
+	}
+
+	dir := fmt.Sprintf(backupDirPath, nodeId)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, name), nil
+}
@@ -15,6 +15,31 @@ type Block struct {
 	Height       int
 }
 
+// the part of a block covered by its proof-of-work, enough to check a block without its transactions
+type BlockHeader struct {
+	Timestamp  int64
+	Hash       []byte
+	PrevHash   []byte
+	MerkleRoot []byte // the root of the merkle tree built from the block's transactions
+	Nonce      int
+	Height     int
+}
+
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.Timestamp, b.Hash, b.PrevHash, b.HashTransactions(), b.Nonce, b.Height}
+}
+
+// build a proof that the transaction at index is included in the block, to be checked against the header's merkle root
+func (b *Block) MerkleProof(index int) []MerkleStep {
+	var txHashes [][]byte
+
+	for _, tx := range b.Transactions {
+		txHashes = append(txHashes, tx.Serialize())
+	}
+
+	return newMerkleProof(txHashes, index)
+}
+
 // helper function to hash the blocks' transactions
 func (b *Block) HashTransactions() []byte {
 	var txHashes [][]byte
@@ -28,9 +53,14 @@ func (b *Block) HashTransactions() []byte {
 }
 
 // create a new instance of block with the given parameters
-func createBlock(transactions []*Transaction, prevHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), []byte{}, transactions, prevHash, 0, height}
-	pow := NewProof(block) // proove block's creation
+func createBlock(transactions []*Transaction, prevHash []byte, height, difficulty int) *Block {
+	return createBlockAt(transactions, prevHash, height, difficulty, time.Now().Unix())
+}
+
+// create a block with a fixed timestamp, the proof-of-work then always finds the same nonce and hash
+func createBlockAt(transactions []*Transaction, prevHash []byte, height, difficulty int, timestamp int64) *Block {
+	block := &Block{timestamp, []byte{}, transactions, prevHash, 0, height}
+	pow := NewProof(block, difficulty) // proove block's creation
 	nonce, hash := pow.Run()
 
 	block.Hash = hash[:]
@@ -40,8 +70,8 @@ func createBlock(transactions []*Transaction, prevHash []byte, height int) *Bloc
 }
 
 // create a genesis block exists — without it, the first "real" block would now have a previous block hash to reference
-func genesis(coinbase *Transaction) *Block {
-	return createBlock([]*Transaction{coinbase}, []byte{}, 0)
+func genesis(coinbase *Transaction, difficulty int) *Block {
+	return createBlock([]*Transaction{coinbase}, []byte{}, 0, difficulty)
 }
 
 // GO's BadgerDB requires byte slices, so a Serialize() needs to exist
@@ -57,11 +87,9 @@ func (b *Block) Serialize() []byte {
 
 // GO's BadgerDB requires byte slices, so a Deserialize() needs to exist
 func Deserialize(data []byte) (b *Block) {
-	var block Block
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
+	block, err := deserializeBlock(data)
 
 	Handle(err)
 
-	return &block
+	return block
 }
@@ -9,6 +9,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"slices"
 	"strings"
@@ -18,12 +19,26 @@ import (
 
 const (
 	dbPath      = "./tmp/blocks_%s"
-	genesisData = "First Transaction from genesis"
+	chainDBPath = "./tmp/blocks_%s_%s"
+	MainChainID = "main" // the chain every node hosts by default
+)
+
+var (
+	chainIDKey        = []byte("cid")
+	errChainNotFound  = errors.New("BlockChain has not been created yet. Create one!")
+	errChainExists    = errors.New("Blockchain already exists")
+	errChainIDMissing = errors.New("chain ID must not be empty")
+
+	// chain IDs are part of database paths, so they are kept to characters that cannot leave the data directory
+	chainIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
 )
 
 type BlockChain struct {
 	LastHash []byte
 	Database *badger.DB
+	ChainID  string // identifies the chain when several are hosted by the same process
+	Params   Params // the parameters the chain was created with
+	path     string // the database directory, holding the open marker while the chain is open
 }
 
 // helper function to check if MANIFEST file exists, i.e., the DB
@@ -34,48 +49,157 @@ func DBexists(path string) bool {
 	return true
 }
 
+// check that a chain ID can name a chain: lowercase letters, digits and dashes
+func ValidateChainID(chainID string) error {
+	if chainID == "" {
+		return errChainIDMissing
+	}
+	if !chainIDPattern.MatchString(chainID) {
+		return fmt.Errorf("chain ID %q may only hold lowercase letters, digits and dashes", chainID)
+	}
+	return nil
+}
+
+// the database path of a chain hosted by a node
+// the main chain keeps the original layout so existing databases keep working
+func ChainPath(chainID, nodeId string) string {
+	if chainID == MainChainID {
+		return fmt.Sprintf(dbPath, nodeId)
+	}
+	return fmt.Sprintf(chainDBPath, nodeId, chainID)
+}
+
 // fetch existing blockchain and continue the chain
 func ContinueBlockChain(nodeId string) *BlockChain {
-	path := fmt.Sprintf(dbPath, nodeId)
-	if !DBexists(path) {
-		fmt.Println("BlockChain has not been created yet. Create one!")
+	return ContinueChain(MainChainID, nodeId)
+}
+
+// fetch one of the node's chains and continue it
+func ContinueChain(chainID, nodeId string) *BlockChain {
+	if err := ValidateChainID(chainID); err != nil {
+		fmt.Println(err)
 		runtime.Goexit()
 	}
 
-	var lastHash []byte
+	chain, err := openBlockChain(chainID, ChainPath(chainID, nodeId))
+	if errors.Is(err, errChainNotFound) {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+	Handle(err)
+
+	return chain
+}
+
+// create a new instance of a blockchain with a genesis block and transaction
+func CreateBlockChain(address, nodeId string) *BlockChain {
+	return CreateChain(address, MainChainID, nodeId)
+}
+
+// create another chain hosted by the node, e.g. a sidechain
+func CreateChain(address, chainID, nodeId string) *BlockChain {
+	return CreateChainWithParams(address, ParamsFor(chainID), nodeId)
+}
+
+// create a chain of another network, defined by its parameters
+func CreateChainWithParams(address string, params Params, nodeId string) *BlockChain {
+	if err := ValidateChainID(params.ChainID); err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+
+	chain, err := newBlockChain(address, params, ChainPath(params.ChainID, nodeId))
+	if errors.Is(err, errChainExists) {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+	Handle(err)
+
+	return chain
+}
+
+// open the chain stored at path, refusing it if it was created for another chain ID
+func openBlockChain(chainID, path string) (*BlockChain, error) {
+	if err := ValidateChainID(chainID); err != nil {
+		return nil, err
+	}
+	if !DBexists(path) {
+		return nil, errChainNotFound
+	}
 
 	opts := badger.DefaultOptions(path)
 	opts.ValueDir = path
 	db, err := openDB(path, opts)
-	Handle(err)
+	if err != nil {
+		return nil, err
+	}
 
-	// fetch blockchains' last hash pointer
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
+	storedID, err := readChainID(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if storedID != chainID {
+		db.Close()
+		return nil, fmt.Errorf("database at %s belongs to chain %q, not %q", path, storedID, chainID)
+	}
 
-		err = item.Value(func(v []byte) error {
-			// this func with val would only be called if item.Value() encounters no error.
-			lastHash = slices.Clone(v)
-			return nil
-		})
+	params, err := readParams(db, chainID)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	// proofs of the chain, such as its anchors, are checked against the parameters it was created with
+	if err := RegisterParams(params); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-		return err
-	})
+	dirty, err := markOpen(path)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if dirty {
+		log.Println("database was not closed cleanly, checking the whole chain")
+	}
 
-	Handle(err)
+	// fetch blockchains' last hash pointer, repairing it if a crash left it dangling
+	chain := BlockChain{nil, db, chainID, params, path}
+	chain.recoverTip(dirty || FullCheckOnOpen)
 
-	chain := BlockChain{lastHash, db}
+	// databases from before the indexes existed, or whose rebuild was interrupted, are indexed again
+	if !chain.indexesMatchTip() {
+		log.Println("derived indexes do not match the chain tip, rebuilding them")
+		chain.reindex()
+	}
 
-	return &chain
+	return &chain, nil
 }
 
-// create a new instance of a blockchain with a genesis block and transaction
-func CreateBlockChain(address, nodeId string) *BlockChain {
-	path := fmt.Sprintf(dbPath, nodeId)
+// create the chain at path with a genesis block mined for the given address
+func newBlockChain(address string, params Params, path string) (*BlockChain, error) {
 	if DBexists(path) {
-		fmt.Println("Blockchain already exists")
-		runtime.Goexit()
+		return nil, errChainExists
+	}
+	if err := RegisterParams(params); err != nil {
+		return nil, err
+	}
+
+	coinbaseTransaction := newCoinbaseTx(address, params.GenesisData, params.Reward(0))
+	genesisBlock := genesis(coinbaseTransaction, params.Difficulty)
+	fmt.Println("Genesis block created")
+
+	return newBlockChainFrom(genesisBlock, params, path)
+}
+
+// create a chain database starting at the given genesis block
+func newBlockChainFrom(genesisBlock *Block, params Params, path string) (*BlockChain, error) {
+	if err := ValidateChainID(params.ChainID); err != nil {
+		return nil, err
+	}
+	if DBexists(path) {
+		return nil, errChainExists
 	}
 
 	var lastHash []byte
@@ -83,28 +207,64 @@ func CreateBlockChain(address, nodeId string) *BlockChain {
 	opts := badger.DefaultOptions(path)
 	opts.ValueDir = path
 	db, err := openDB(path, opts)
-	Handle(err)
+	if err != nil {
+		return nil, err
+	}
 
 	// set blockchains' last hash pointer
 	err = db.Update(func(txn *badger.Txn) error {
-		coinbaseTransaction := CoinbaseTx(address, genesisData)
-		genesisBlock := genesis(coinbaseTransaction)
-		fmt.Println("Genesis block created")
-
 		err = txn.Set(genesisBlock.Hash, genesisBlock.Serialize())
 		Handle(err)
+		err = txn.Set(chainIDKey, []byte(params.ChainID))
+		Handle(err)
+		err = txn.Set(paramsKey, encodeParams(params))
+		Handle(err)
+		err = connectBlock(txn, genesisBlock)
+		Handle(err)
 		err = txn.Set([]byte("lh"), genesisBlock.Hash)
 
 		lastHash = genesisBlock.Hash
 
 		return err
 	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	Handle(err)
+	if _, err := markOpen(path); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	blockChain := BlockChain{lastHash, db, params.ChainID, params, path}
+
+	return &blockChain, nil
+}
+
+// read the chain ID a database was created with
+// databases created before chain IDs existed belong to the main chain
+func readChainID(db *badger.DB) (string, error) {
+	chainID := MainChainID
 
-	blockChain := BlockChain{lastHash, db}
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(chainIDKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		chainID = string(value)
+		return ValidateChainID(chainID)
+	})
 
-	return &blockChain
+	return chainID, err
 }
 
 func (chain *BlockChain) GetBlockHashes() [][]byte {
@@ -189,65 +349,182 @@ func (chain *BlockChain) MineBlock(transactions []*Transaction) *Block {
 		return err
 	})
 
-	newBlock := createBlock(transactions, lastHash, lastHeight+1)
+	newBlock := createBlock(transactions, lastHash, lastHeight+1, chain.Params.Difficulty)
 	fmt.Println("lastheight is", lastHeight+1)
 
-	// set blockchains' last hash pointer
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err = txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
+	// store the block and connect it, which also sets blockchains' last hash pointer
+	err = chain.ConnectBlock(newBlock)
+	Handle(err)
+
+	return newBlock
+}
+
+// stored children: parent hash + child hash, for every block received from another node, so blocks arriving
+// before their parent are connected once it arrives
+var ChildPrefix = []byte("child-")
 
-		chain.LastHash = newBlock.Hash
+func childKey(parent, child []byte) []byte {
+	return append(append(slicesCopy(ChildPrefix), parent...), child...)
+}
+
+// the tallest block stored on top of a block, the block itself if none is
+func bestDescendant(txn *badger.Txn, block *Block) (*Block, error) {
+	seek := append(slicesCopy(ChildPrefix), block.Hash...)
+
+	var children [][]byte
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	for it.Seek(seek); it.ValidForPrefix(seek); it.Next() {
+		children = append(children, it.Item().KeyCopy(nil)[len(seek):])
+	}
+	it.Close()
 
+	best := block
+	for _, hash := range children {
+		child, err := getBlock(txn, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		descendant, err := bestDescendant(txn, child)
+		if err != nil {
+			return nil, err
+		}
+		if descendant.Height > best.Height {
+			best = descendant
+		}
+	}
+
+	return best, nil
+}
+
+// store a block received from another node, switching the main chain over if the block's branch is now the tallest
+// blocks stored on top of it before it arrived are connected along with it, a block that does not connect is
+// dropped along with the blocks built on it and the chain stays as it was
+func (chain *BlockChain) AddBlock(block *Block) error {
+	for {
+		connected, disconnected, err := chain.addBlock(block)
+
+		var invalid *invalidBlockError
+		if errors.As(err, &invalid) && !bytes.Equal(invalid.Hash, block.Hash) {
+			// a descendant stored earlier does not connect, forget it and connect the block without it
+			log.Printf("dropping stored block %x: %v", invalid.Hash, invalid.Err)
+			if err := chain.forgetBlock(invalid.Hash); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		runDisconnectHooks(chain.ChainID, disconnected)
+		runConnectHooks(chain.ChainID, connected)
+		break
+	}
+
+	lastHash, err := readLastHash(chain.Database)
+	if err != nil {
 		return err
-	})
+	}
 
-	Handle(err)
+	// only the new tip's activations are reported, a reorganization may have connected several blocks at once
+	if !bytes.Equal(lastHash, chain.LastHash) {
+		chain.runActivations(chain.GetStats().Height)
+	}
+	chain.LastHash = lastHash
 
-	return newBlock
+	return nil
 }
 
-func (chain *BlockChain) AddBlock(block *Block) {
+// store a block and connect the tallest branch it completes, all in one badger transaction, so nothing of it is
+// written when a block of the branch fails to connect
+// the blocks connected, oldest first, and the blocks disconnected, newest first, are returned
+func (chain *BlockChain) addBlock(block *Block) ([]*Block, []*Block, error) {
+	var connected, disconnected []*Block
+
 	err := chain.Database.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get(block.Hash); err != nil {
+		// the block is already known
+		if _, err := txn.Get(block.Hash); err == nil {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		Handle(err)
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if len(block.PrevHash) > 0 {
+			if err := txn.Set(childKey(block.PrevHash, block.Hash), []byte{}); err != nil {
+				return err
+			}
+		}
 
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
+		lastHash, err := getValue(txn, []byte("lh"))
+		if err != nil {
+			return err
+		}
 
-		var lastHash []byte
-		err = item.Value(func(v []byte) error {
-			// this func with val would only be called if item.Value() encounters no error.
-			lastHash = slices.Clone(v)
+		lastBlock, err := getBlock(txn, lastHash)
+		if err != nil {
+			return err
+		}
+
+		newTip, err := bestDescendant(txn, block)
+		if err != nil {
+			return err
+		}
+		if newTip.Height <= lastBlock.Height {
 			return nil
-		})
+		}
 
-		item, err = txn.Get(lastHash)
+		// the common case: the block extends the tip
+		if newTip == block && bytes.Equal(block.PrevHash, lastHash) {
+			if err := connectReceivedBlock(txn, block); err != nil {
+				return err
+			}
+			connected = []*Block{block}
+			return txn.Set([]byte("lh"), block.Hash)
+		}
 
-		var lastBlockData []byte
-		err = item.Value(func(v []byte) error {
-			// this func with val would only be called if item.Value() encounters no error.
-			lastBlockData = slices.Clone(v)
-			return nil
-		})
+		connected, disconnected, err = reorganize(txn, lastBlock, newTip)
+		if len(disconnected) > 0 {
+			log.Printf("reorganized the chain onto block %x at height %d", newTip.Hash, newTip.Height)
+		}
 
-		lastBlock := Deserialize(lastBlockData)
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return connected, disconnected, nil
+}
 
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("lh"), block.Hash)
-			Handle(err)
-			chain.LastHash = block.Hash
+// delete a stored block that does not connect, so it is no longer a descendant of its parent, nor anything built
+// on it a descendant of it
+func (chain *BlockChain) forgetBlock(hash []byte) error {
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		block, err := getBlock(txn, hash)
+		if err != nil {
+			return err
 		}
 
-		return nil
+		seek := append(slicesCopy(ChildPrefix), hash...)
+		var children [][]byte
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		for it.Seek(seek); it.ValidForPrefix(seek); it.Next() {
+			children = append(children, it.Item().KeyCopy(nil))
+		}
+		it.Close()
+
+		for _, key := range children {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		if err := txn.Delete(childKey(block.PrevHash, hash)); err != nil {
+			return err
+		}
+		return txn.Delete(hash)
 	})
-	Handle(err)
 }
 
 func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
@@ -346,6 +623,33 @@ func (chain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 	return Transaction{}, errors.New("Transaction does not exist")
 }
 
+// find the main chain block holding a transaction, along with the transaction's index in it
+// the blocks built on top of it are returned too, oldest first, as they confirm the transaction
+func (chain *BlockChain) locateTransaction(txID []byte) (*Block, int, []*Block, error) {
+	var above []*Block
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for txIdx, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, txID) {
+				slices.Reverse(above)
+				return block, txIdx, above, nil
+			}
+		}
+
+		above = append(above, block)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return nil, 0, nil, errors.New("Transaction does not exist")
+}
+
 // sign a transaction using the private key
 func (chain *BlockChain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) {
 	previousTXs := make(map[string]Transaction)
@@ -363,17 +667,27 @@ func (chain *BlockChain) SignTransaction(tx *Transaction, privateKey ecdsa.Priva
 
 // verify a transaction using the public key
 func (chain *BlockChain) VerifyTransaction(tx *Transaction) bool {
+	return chain.VerifyPoolTransaction(tx, nil)
+}
+
+// verify a transaction that may spend the outputs of transactions still waiting in the pool
+func (chain *BlockChain) VerifyPoolTransaction(tx *Transaction, pool map[string]Transaction) bool {
+	if CheckTransaction(tx) != nil {
+		return false
+	}
 	if tx.isCoinbase() {
 		return true
 	}
 
-	previousTXs := make(map[string]Transaction)
+	// claims create coins moved from another chain, so they are backed by a proof instead of inputs
+	if tx.isBridgeClaim() {
+		return chain.verifyBridgeClaim(tx, pool)
+	}
 
 	// locate every previous transaction that is referenced by the input
-	for _, in := range tx.Inputs {
-		previousTX, err := chain.FindTransaction(in.ID)
-		Handle(err)
-		previousTXs[hex.EncodeToString(previousTX.ID)] = previousTX
+	previousTXs, err := chain.previousTransactions(tx, pool)
+	if err != nil {
+		return false
 	}
 
 	// verify the transaction
@@ -394,6 +708,15 @@ func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
 
 func openDB(dir string, opts badger.Options) (*badger.DB, error) {
 	if db, err := badger.Open(opts); err != nil {
+		// a torn write at the end of the value log, drop the partial entry and carry on
+		if strings.Contains(err.Error(), badger.ErrTruncateNeeded.Error()) {
+			truncateOpts := opts
+			truncateOpts.Truncate = true
+			if db, err := badger.Open(truncateOpts); err == nil {
+				log.Println("value log had a torn write, truncated it")
+				return db, nil
+			}
+		}
 		if strings.Contains(err.Error(), "LOCK") {
 			if db, err := retry(dir, opts); err == nil {
 				log.Println("database unlocked, value log truncated")
@@ -0,0 +1,545 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+
+	"github.com/dgraph-io/badger"
+)
+
+// a two-way peg between chains running this package:
+// 1. coins are locked on their origin chain by a transfer output naming the destination chain and address
+// 2. once the transfer is buried under enough blocks, anyone can build an SPV proof of it
+// 3. the destination chain checks the proof against the headers it tracks of the source chain and mints the
+// mirrored coins with a claim transaction
+// 4. burning the mirrored coins works the same way in the opposite direction and unlocks the original coins
+// the headers of a source chain are relayed into the destination chain's database from the node's own copy of the
+// source chain, so a claim is only accepted by nodes following the source chain, and only for a block of its main
+// chain buried under enough of its blocks, a proof carrying headers made up by anyone else is turned away
+const (
+	BridgeLock = "lock" // native coins are locked and minted on the destination chain
+	BridgeBurn = "burn" // mirrored coins are burnt and unlocked on their origin chain
+
+	BridgeConfirmations = 2 // the number of blocks that must be built on top of a transfer before it can be claimed
+)
+
+// the payload of a transfer output
+type BridgeTransfer struct {
+	Kind              string // BridgeLock or BridgeBurn
+	DestChain         string // the chain ID the coins are moving to
+	DestPublicKeyHash []byte // the hashed address that receives the coins on the destination chain
+}
+
+// an SPV proof that a transfer output was included in, and confirmed by, the source chain
+type BridgeProof struct {
+	SourceChain string
+	Transaction Transaction   // the transaction holding the transfer output
+	Output      int           // the index of the transfer output
+	MerklePath  []MerkleStep  // proves the transaction is part of the first header's block
+	Headers     []BlockHeader // the block including the transaction, followed by the blocks confirming it
+}
+
+// tracked headers: source chain ID + ":" + height -> the main chain header at that height
+// tracked tips: source chain ID -> the height of the latest tracked header
+var (
+	BridgeHeaderPrefix = []byte("bhdr-")
+	BridgeTipPrefix    = []byte("btip-")
+)
+
+// bridge index, kept by connecting blocks:
+// claimed transfers: transfer key -> nothing
+// totals: chain ID -> the coins locked for it, or minted from it
+var (
+	BridgeClaimPrefix  = []byte("bridge-claimed-")
+	BridgeLockedPrefix = []byte("bridge-locked-")
+	BridgeMintedPrefix = []byte("bridge-minted-")
+)
+
+// the bridge's bookkeeping, derived from the transfers and claims found on a chain
+type BridgeState struct {
+	Locked map[string]int // native coins held for each destination chain, released by burns coming back
+	Minted map[string]int // mirrored coins in circulation for each origin chain
+}
+
+func (t BridgeTransfer) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	encode := gob.NewEncoder(&buffer)
+	err := encode.Encode(t)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+func deserializeBridgeTransfer(data []byte) (BridgeTransfer, error) {
+	var transfer BridgeTransfer
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	err := decode.Decode(&transfer)
+
+	return transfer, err
+}
+
+func (p *BridgeProof) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	encode := gob.NewEncoder(&buffer)
+	err := encode.Encode(p)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+func DeserializeBridgeProof(data []byte) (*BridgeProof, error) {
+	var proof BridgeProof
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	if err := decode.Decode(&proof); err != nil {
+		return nil, err
+	}
+
+	return &proof, nil
+}
+
+// read the transfer a data output carries, if it carries one
+func (out *TransactionOutput) bridgeTransfer() (BridgeTransfer, bool) {
+	kind, payload := out.payload()
+	if kind != DataBridgeTransfer {
+		return BridgeTransfer{}, false
+	}
+
+	transfer, err := deserializeBridgeTransfer(payload)
+	if err != nil {
+		return BridgeTransfer{}, false
+	}
+
+	return transfer, true
+}
+
+// a claim has no inputs, pays the released coins with its first output and carries the proof in its second
+func (tx *Transaction) isBridgeClaim() bool {
+	if len(tx.Inputs) != 0 || len(tx.Outputs) != 2 {
+		return false
+	}
+
+	kind, _ := tx.Outputs[1].payload()
+	return kind == DataBridgeClaim
+}
+
+func (tx *Transaction) bridgeProof() (*BridgeProof, error) {
+	if !tx.isBridgeClaim() {
+		return nil, errors.New("transaction is not a bridge claim")
+	}
+
+	_, payload := tx.Outputs[1].payload()
+	return DeserializeBridgeProof(payload)
+}
+
+// the key identifying a transfer, so that it can only be claimed once
+func bridgeTransferKey(sourceChain string, txID []byte, output int) string {
+	return fmt.Sprintf("%s:%x:%d", sourceChain, txID, output)
+}
+
+// create a transaction moving coins from the wallet to an address on another chain
+// burns are only accepted while enough mirrored coins of the destination chain circulate here
+func NewBridgeTransfer(w *wallet.Wallet, kind, destChain, destAddress string, amount int, UTXO *UTXOSet) (*Transaction, error) {
+	if kind != BridgeLock && kind != BridgeBurn {
+		return nil, fmt.Errorf("unknown bridge transfer kind %q", kind)
+	}
+
+	if destChain == "" || destChain == UTXO.Blockchain.ChainID {
+		return nil, errors.New("the destination must be another chain")
+	}
+
+	if !wallet.ValidateAddress(destAddress) {
+		return nil, errors.New("destination address is invalid")
+	}
+
+	if kind == BridgeBurn {
+		state := UTXO.Blockchain.BridgeState()
+		if state.Minted[destChain] < amount {
+			return nil, fmt.Errorf("only %d coins from chain %s can be burnt", state.Minted[destChain], destChain)
+		}
+	}
+
+	destPublicKeyHash := wallet.Base58Decode([]byte(destAddress))
+	destPublicKeyHash = destPublicKeyHash[1 : len(destPublicKeyHash)-4]
+
+	transfer := BridgeTransfer{kind, destChain, destPublicKeyHash}
+	outputs := []TransactionOutput{*NewDataOutput(amount, DataBridgeTransfer, transfer.Serialize())}
+
+	return newSpendingTransaction(w, amount, 0, outputs, UTXO), nil
+}
+
+// build the SPV proof of a transfer transaction once it is confirmed by enough blocks
+func (chain *BlockChain) NewBridgeProof(txID []byte) (*BridgeProof, error) {
+	block, txIdx, above, err := chain.locateTransaction(txID)
+	if err != nil {
+		return nil, err
+	}
+	tx := block.Transactions[txIdx]
+
+	output := -1
+	for outIdx, out := range tx.Outputs {
+		if _, ok := out.bridgeTransfer(); ok {
+			output = outIdx
+			break
+		}
+	}
+	if output == -1 {
+		return nil, errors.New("transaction holds no bridge transfer")
+	}
+
+	if len(above) < BridgeConfirmations {
+		return nil, fmt.Errorf("transfer has %d confirmations, %d are needed", len(above), BridgeConfirmations)
+	}
+
+	headers := []BlockHeader{block.Header()}
+	for _, confirming := range above[:BridgeConfirmations] {
+		headers = append(headers, confirming.Header())
+	}
+
+	proof := BridgeProof{chain.ChainID, *tx, output, block.MerkleProof(txIdx), headers}
+	return &proof, nil
+}
+
+// check a proof on its own: the headers carry valid proof-of-work, link together and include the transfer
+// whether the headers belong to the source chain is checked against the tracked headers by CheckBridgeClaim
+func (p *BridgeProof) verify() (BridgeTransfer, int, error) {
+	if len(p.Headers) < BridgeConfirmations+1 {
+		return BridgeTransfer{}, 0, errors.New("not enough confirming headers")
+	}
+
+	if err := CheckHeaderChain(p.Headers, ParamsFor(p.SourceChain).Difficulty); err != nil {
+		return BridgeTransfer{}, 0, err
+	}
+
+	if !VerifyMerkleProof(p.Transaction.Serialize(), p.MerklePath, p.Headers[0].MerkleRoot) {
+		return BridgeTransfer{}, 0, errors.New("transaction is not part of the proven block")
+	}
+
+	if p.Output < 0 || p.Output >= len(p.Transaction.Outputs) {
+		return BridgeTransfer{}, 0, errors.New("proof points at a missing output")
+	}
+
+	out := p.Transaction.Outputs[p.Output]
+	transfer, ok := out.bridgeTransfer()
+	if !ok {
+		return BridgeTransfer{}, 0, errors.New("proof points at an output that is not a bridge transfer")
+	}
+
+	return transfer, out.Value, nil
+}
+
+// create the transaction releasing the coins of a proven transfer on its destination chain
+func NewBridgeClaim(proof *BridgeProof) (*Transaction, error) {
+	transfer, value, err := proof.verify()
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := []TransactionOutput{
+		{value, transfer.DestPublicKeyHash},
+		*NewDataOutput(0, DataBridgeClaim, proof.Serialize()),
+	}
+
+	tx := Transaction{nil, nil, outputs}
+	tx.ID = tx.hash()
+
+	return &tx, nil
+}
+
+// check a claim against this chain: the proof holds, its block is part of the tracked source chain under enough
+// tracked blocks, it targets this chain, pays what was sent and was claimed neither before nor by another
+// transaction of the pool
+func (chain *BlockChain) CheckBridgeClaim(tx *Transaction, pool map[string]Transaction) error {
+	key := tx.claimedTransfer()
+	for _, pooled := range pool {
+		if key != "" && !bytes.Equal(pooled.ID, tx.ID) && pooled.claimedTransfer() == key {
+			return fmt.Errorf("transfer %s is claimed by transaction %x too", key, pooled.ID)
+		}
+	}
+
+	return chain.Database.View(func(txn *badger.Txn) error {
+		return checkBridgeClaim(txn, chain.ChainID, tx)
+	})
+}
+
+// check a claim against the bridge index and the tracked headers of the chain with the given ID
+func checkBridgeClaim(txn *badger.Txn, chainID string, tx *Transaction) error {
+	proof, err := tx.bridgeProof()
+	if err != nil {
+		return err
+	}
+
+	transfer, value, err := proof.verify()
+	if err != nil {
+		return err
+	}
+
+	if transfer.DestChain != chainID || proof.SourceChain == chainID {
+		return errors.New("the transfer is not bound for this chain")
+	}
+
+	included := proof.Headers[0]
+	hash, tip, err := getTrackedHeader(txn, proof.SourceChain, included.Height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, included.Hash) {
+		return fmt.Errorf("block %x is not part of the tracked chain %s", included.Hash, proof.SourceChain)
+	}
+	if tip-included.Height < BridgeConfirmations {
+		return fmt.Errorf("the transfer has %d tracked confirmations, %d are needed", tip-included.Height, BridgeConfirmations)
+	}
+
+	payout := tx.Outputs[0]
+	if payout.IsData() || payout.Value != value || !bytes.Equal(payout.PublicKeyHash, transfer.DestPublicKeyHash) {
+		return errors.New("the claim does not pay what was sent")
+	}
+
+	key := bridgeTransferKey(proof.SourceChain, proof.Transaction.ID, proof.Output)
+	if claimed, err := hasKey(txn, bridgeClaimKey(key)); err != nil {
+		return err
+	} else if claimed {
+		return fmt.Errorf("transfer %s was claimed before", key)
+	}
+
+	// coins coming back can only unlock what was locked for that chain in the first place
+	if transfer.Kind == BridgeBurn {
+		data, err := getValue(txn, append(slicesCopy(BridgeLockedPrefix), proof.SourceChain...))
+		if err != nil {
+			return err
+		}
+		locked := 0
+		if data != nil {
+			locked = int(int64(binary.BigEndian.Uint64(data)))
+		}
+		if locked < value {
+			return fmt.Errorf("only %d coins are locked for chain %s", locked, proof.SourceChain)
+		}
+	}
+
+	return nil
+}
+
+func (chain *BlockChain) verifyBridgeClaim(tx *Transaction, pool map[string]Transaction) bool {
+	return chain.CheckBridgeClaim(tx, pool) == nil
+}
+
+// the key of the transfer a claim releases, empty if the transaction is no claim
+func (tx *Transaction) claimedTransfer() string {
+	if !tx.isBridgeClaim() {
+		return ""
+	}
+
+	proof, err := tx.bridgeProof()
+	if err != nil {
+		return ""
+	}
+	return bridgeTransferKey(proof.SourceChain, proof.Transaction.ID, proof.Output)
+}
+
+func bridgeHeaderKey(sourceChain string, height int) []byte {
+	key := append(slicesCopy(BridgeHeaderPrefix), sourceChain+":"...)
+	return binary.BigEndian.AppendUint64(key, uint64(height))
+}
+
+func bridgeTipKey(sourceChain string) []byte {
+	return append(slicesCopy(BridgeTipPrefix), sourceChain...)
+}
+
+// the hash of the tracked header of a source chain at the given height, nil if none is tracked there, and the
+// height of the latest tracked header, -1 if the source chain is not tracked
+func (chain *BlockChain) trackedHeaderHash(sourceChain string, height int) ([]byte, int, error) {
+	var hash []byte
+	tip := -1
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		hash, tip, err = getTrackedHeader(txn, sourceChain, height)
+		return err
+	})
+
+	return hash, tip, err
+}
+
+func getTrackedHeader(txn *badger.Txn, sourceChain string, height int) ([]byte, int, error) {
+	data, err := getValue(txn, bridgeTipKey(sourceChain))
+	if err != nil || data == nil {
+		return nil, -1, err
+	}
+	tip := int(binary.BigEndian.Uint64(data))
+
+	data, err = getValue(txn, bridgeHeaderKey(sourceChain, height))
+	if err != nil || data == nil {
+		return nil, tip, err
+	}
+
+	var header BlockHeader
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&header); err != nil {
+		return nil, tip, err
+	}
+	return header.Hash, tip, nil
+}
+
+// follow the main chain of a source chain the node hosts, copying the headers this chain has not tracked yet and
+// replacing the ones a reorganization of the source chain took off its main chain, returning how many were copied
+// the headers are checked against the source chain's difficulty and must link up with the ones tracked already
+func (chain *BlockChain) TrackSourceChain(source *BlockChain) (int, error) {
+	if source.ChainID == chain.ChainID {
+		return 0, errors.New("a chain cannot track itself")
+	}
+
+	sourceTip := source.GetBestHeight()
+	_, tip, err := chain.trackedHeaderHash(source.ChainID, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	// walk down to the highest header both agree on, the headers above it are copied
+	fork := min(tip, sourceTip)
+	for ; fork >= 0; fork-- {
+		tracked, _, err := chain.trackedHeaderHash(source.ChainID, fork)
+		if err != nil {
+			return 0, err
+		}
+		hash, err := source.GetBlockHashAt(fork)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(tracked, hash) {
+			break
+		}
+	}
+
+	var headers []BlockHeader
+	for height := max(fork, 0); height <= sourceTip; height++ {
+		hash, err := source.GetBlockHashAt(height)
+		if err != nil {
+			return 0, err
+		}
+		block, err := source.GetBlock(hash)
+		if err != nil {
+			return 0, err
+		}
+		headers = append(headers, block.Header())
+	}
+	if err := CheckHeaderChain(headers, source.Params.Difficulty); err != nil {
+		return 0, err
+	}
+	if fork >= 0 {
+		headers = headers[1:]
+	}
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		for _, header := range headers {
+			if err := txn.Set(bridgeHeaderKey(source.ChainID, header.Height), encodeGob(header)); err != nil {
+				return err
+			}
+		}
+		for height := sourceTip + 1; height <= tip; height++ {
+			if err := txn.Delete(bridgeHeaderKey(source.ChainID, height)); err != nil {
+				return err
+			}
+		}
+		return txn.Set(bridgeTipKey(source.ChainID), binary.BigEndian.AppendUint64(nil, uint64(sourceTip)))
+	})
+
+	return len(headers), err
+}
+
+// the bridge's bookkeeping on the main chain
+func (chain *BlockChain) BridgeState() BridgeState {
+	state := BridgeState{make(map[string]int), make(map[string]int)}
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		for prefix, totals := range map[string]map[string]int{string(BridgeLockedPrefix): state.Locked, string(BridgeMintedPrefix): state.Minted} {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			for it.Seek([]byte(prefix)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+				value, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					it.Close()
+					return err
+				}
+				totals[string(it.Item().Key()[len(prefix):])] = int(int64(binary.BigEndian.Uint64(value)))
+			}
+			it.Close()
+		}
+		return nil
+	})
+	Handle(err)
+
+	return state
+}
+
+// the changes a transaction makes to the bridge's bookkeeping: the totals of each chain and the transfer it claims
+func bridgeChanges(tx *Transaction) (locked, minted map[string]int, claimed string) {
+	locked, minted = make(map[string]int), make(map[string]int)
+
+	if tx.isBridgeClaim() {
+		proof, err := tx.bridgeProof()
+		if err != nil {
+			return locked, minted, ""
+		}
+
+		transfer, value, err := proof.verify()
+		if err != nil {
+			return locked, minted, ""
+		}
+
+		if transfer.Kind == BridgeLock {
+			minted[proof.SourceChain] += value
+		} else {
+			locked[proof.SourceChain] -= value
+		}
+		return locked, minted, bridgeTransferKey(proof.SourceChain, proof.Transaction.ID, proof.Output)
+	}
+
+	for _, out := range tx.Outputs {
+		transfer, ok := out.bridgeTransfer()
+		if !ok {
+			continue
+		}
+
+		if transfer.Kind == BridgeLock {
+			locked[transfer.DestChain] += out.Value
+		} else {
+			minted[transfer.DestChain] -= out.Value
+		}
+	}
+
+	return locked, minted, ""
+}
+
+// update the bridge index for a connected transaction, or with sign -1 for a disconnected one
+func indexBridge(txn *badger.Txn, tx *Transaction, sign int) error {
+	locked, minted, claimed := bridgeChanges(tx)
+
+	for chainID, delta := range locked {
+		if err := addCounter(txn, append(slicesCopy(BridgeLockedPrefix), chainID...), sign*delta); err != nil {
+			return err
+		}
+	}
+	for chainID, delta := range minted {
+		if err := addCounter(txn, append(slicesCopy(BridgeMintedPrefix), chainID...), sign*delta); err != nil {
+			return err
+		}
+	}
+
+	if claimed == "" {
+		return nil
+	}
+	if sign < 0 {
+		return txn.Delete(bridgeClaimKey(claimed))
+	}
+	return txn.Set(bridgeClaimKey(claimed), []byte{})
+}
+
+func bridgeClaimKey(transfer string) []byte {
+	return append(slicesCopy(BridgeClaimPrefix), transfer...)
+}
@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Chains hosts several independent blockchains in the same process, keyed by their chain ID
+// every chain has its own database, so they never share blocks, UTXOs or locks
+type Chains struct {
+	mu     sync.RWMutex
+	nodeId string
+	chains map[string]*BlockChain
+}
+
+func NewChains(nodeId string) *Chains {
+	return &Chains{nodeId: nodeId, chains: make(map[string]*BlockChain)}
+}
+
+// open an existing chain and keep its handle, opening it twice returns the same handle
+func (c *Chains) Open(chainID string) (*BlockChain, error) {
+	return c.OpenAt(chainID, ChainPath(chainID, c.nodeId))
+}
+
+// open an existing chain stored in a custom data directory
+func (c *Chains) OpenAt(chainID, path string) (*BlockChain, error) {
+	if err := ValidateChainID(chainID); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if chain, ok := c.chains[chainID]; ok {
+		return chain, nil
+	}
+
+	chain, err := openBlockChain(chainID, path)
+	if err != nil {
+		return nil, err
+	}
+	c.chains[chainID] = chain
+
+	return chain, nil
+}
+
+// create a new chain whose genesis block rewards the given address and keep its handle
+func (c *Chains) Create(chainID, address string) (*BlockChain, error) {
+	return c.CreateAt(chainID, address, ChainPath(chainID, c.nodeId))
+}
+
+// create a new chain in a custom data directory
+func (c *Chains) CreateAt(chainID, address, path string) (*BlockChain, error) {
+	if err := ValidateChainID(chainID); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.chains[chainID]; ok {
+		return nil, fmt.Errorf("chain %q is already open", chainID)
+	}
+
+	chain, err := newBlockChain(address, ParamsFor(chainID), path)
+	if err != nil {
+		return nil, err
+	}
+	c.chains[chainID] = chain
+
+	return chain, nil
+}
+
+// fetch the handle of an open chain
+func (c *Chains) Get(chainID string) (*BlockChain, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	chain, ok := c.chains[chainID]
+	return chain, ok
+}
+
+// list the IDs of every open chain, sorted so the output is stable
+func (c *Chains) IDs() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ids := make([]string, 0, len(c.chains))
+	for id := range c.chains {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	return ids
+}
+
+// close a single chain and forget its handle
+func (c *Chains) Close(chainID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chain, ok := c.chains[chainID]
+	if !ok {
+		return fmt.Errorf("chain %q is not open", chainID)
+	}
+	delete(c.chains, chainID)
+
+	return chain.Close()
+}
+
+// close every open chain, badger needs each database to be closed cleanly
+func (c *Chains) CloseAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, chain := range c.chains {
+		if err := chain.Close(); err != nil {
+			fmt.Printf("could not close chain %s: %s\n", id, err)
+		}
+		delete(c.chains, id)
+	}
+}
@@ -0,0 +1,223 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// coin control: a wallet's unspent outputs can be listed one by one, frozen so automatic coin selection leaves
+// them alone, and spent explicitly, so users decide which coins get linked together and where the change goes
+
+// a single unspent output, referred to as TXID:INDEX
+type Coin struct {
+	TxID          []byte
+	Index         int
+	Value         int
+	Confirmations int
+	Frozen        bool
+	Immature      bool // a coinbase output that cannot be spent yet, see Params.CoinbaseMaturity
+}
+
+func (c Coin) Outpoint() string {
+	return outpoint(TransactionInput{ID: c.TxID, Output: c.Index})
+}
+
+func (c Coin) String() string {
+	s := fmt.Sprintf("%s %d %d confirmations", c.Outpoint(), c.Value, c.Confirmations)
+	if c.Frozen {
+		s += " frozen"
+	}
+	if c.Immature {
+		s += " immature"
+	}
+	return s
+}
+
+// parse a TXID:INDEX reference, returning it in its canonical form
+func ParseOutpoint(s string) (string, error) {
+	txID, index, err := parseOutpoint(s)
+	if err != nil {
+		return "", err
+	}
+
+	return outpoint(TransactionInput{ID: txID, Output: index}), nil
+}
+
+func parseOutpoint(s string) ([]byte, int, error) {
+	id, idx, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("coin %q is not of the form TXID:INDEX", s)
+	}
+
+	txID, err := hex.DecodeString(id)
+	if err != nil || len(txID) == 0 {
+		return nil, 0, fmt.Errorf("coin %q has an invalid transaction ID", s)
+	}
+
+	index, err := strconv.Atoi(idx)
+	if err != nil || index < 0 {
+		return nil, 0, fmt.Errorf("coin %q has an invalid output index", s)
+	}
+
+	return txID, index, nil
+}
+
+// list every unspent output locked with the key, ordered by transaction ID and index
+func (u *UTXOSet) FindCoins(publicKeyHash []byte) []Coin {
+	return u.FindCoinsOf([][]byte{publicKeyHash})[string(publicKeyHash)]
+}
+
+// list the unspent outputs of many keys in a single pass over the UTXO set, keyed by the public key hash
+func (u *UTXOSet) FindCoinsOf(publicKeyHashes [][]byte) map[string][]Coin {
+	coins := make(map[string][]Coin)
+
+	// the address index knows the height of every transaction paying the keys
+	tip := u.Blockchain.GetStats().Height
+	heights := make(map[string]int)
+	immature := make(map[string]bool)
+	for _, publicKeyHash := range publicKeyHashes {
+		for _, atx := range u.Blockchain.AddressTransactions(publicKeyHash) {
+			heights[hex.EncodeToString(atx.TxID)] = atx.Height
+		}
+		maps.Copy(immature, u.Blockchain.immatureCoinbases(publicKeyHash))
+	}
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(UTXOPrefix); it.ValidForPrefix(UTXOPrefix); it.Next() {
+			item := it.Item()
+			txID := bytes.TrimPrefix(item.KeyCopy(nil), UTXOPrefix)
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			outs := DeserializeOutputs(value)
+
+			for position, out := range outs.Outputs {
+				for _, publicKeyHash := range publicKeyHashes {
+					if out.isLockedWithKey(publicKeyHash) {
+						coin := Coin{TxID: txID, Index: outs.index(position), Value: out.Value}
+						if height, ok := heights[hex.EncodeToString(txID)]; ok {
+							coin.Confirmations = tip - height + 1
+						}
+						coin.Frozen = u.Frozen[coin.Outpoint()]
+						coin.Immature = immature[hex.EncodeToString(txID)]
+						coins[string(publicKeyHash)] = append(coins[string(publicKeyHash)], coin)
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+	Handle(err)
+
+	return coins
+}
+
+// read the output a coin refers to, nil if it is spent or never existed
+func (u *UTXOSet) findCoin(txID []byte, index int) (*TransactionOutput, error) {
+	var output *TransactionOutput
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		data, err := getValue(txn, utxoKey(txID))
+		if err != nil || data == nil {
+			return err
+		}
+
+		outs := DeserializeOutputs(data)
+		for position, out := range outs.Outputs {
+			if outs.index(position) == index {
+				output = &out
+			}
+		}
+
+		return nil
+	})
+
+	return output, err
+}
+
+// create a signed transaction funding the outputs and the fee with exactly the given coins of the wallet
+// the coins must be unspent, owned by the wallet and not frozen, whatever they hold beyond the outputs and
+// the fee goes to the change address, the wallet's own address when it is empty
+func NewTransactionFromCoins(w *wallet.Wallet, coins []string, fee int, outputs []TransactionOutput, change string, UTXO *UTXOSet) (*Transaction, error) {
+	if len(coins) == 0 {
+		return nil, errors.New("no coins to spend")
+	}
+	if change == "" {
+		change = string(w.Address())
+	}
+	if !wallet.ValidateAddress(change) {
+		return nil, fmt.Errorf("change address %s is invalid", change)
+	}
+
+	publicKeyHash := wallet.PublicKeyHash(w.PublicKey)
+
+	var inputs []TransactionInput
+	var seen []string
+	acc := 0
+	for _, coin := range coins {
+		txID, index, err := parseOutpoint(coin)
+		if err != nil {
+			return nil, err
+		}
+
+		key := outpoint(TransactionInput{ID: txID, Output: index})
+		if slices.Contains(seen, key) {
+			return nil, fmt.Errorf("coin %s is spent twice", key)
+		}
+		seen = append(seen, key)
+
+		if UTXO.Frozen[key] {
+			return nil, fmt.Errorf("coin %s is frozen", key)
+		}
+		if UTXO.Blockchain.immatureCoinbases(publicKeyHash)[hex.EncodeToString(txID)] {
+			return nil, fmt.Errorf("coin %s is an immature coinbase output", key)
+		}
+
+		out, err := UTXO.findCoin(txID, index)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			return nil, fmt.Errorf("coin %s is not unspent", key)
+		}
+		if !out.isLockedWithKey(publicKeyHash) {
+			return nil, fmt.Errorf("coin %s does not belong to %s", key, w.Address())
+		}
+
+		inputs = append(inputs, TransactionInput{txID, index, nil, w.PublicKey})
+		acc += out.Value
+	}
+
+	amount := fee
+	for _, out := range outputs {
+		amount += out.Value
+	}
+	if acc < amount {
+		return nil, fmt.Errorf("the coins hold %d tokens, %d are needed", acc, amount)
+	}
+
+	if acc > amount {
+		outputs = append(outputs, *NewTransactionOutput(acc-amount, change))
+	}
+
+	tx := Transaction{nil, inputs, outputs}
+	tx.ID = tx.hash()
+	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
+
+	return &tx, nil
+}
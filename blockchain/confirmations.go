@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"golang-blockchain/wallet"
+	"slices"
+	"sync"
+)
+
+// confirmation tracking for wallets: everything is read from the address index, which connecting and disconnecting
+// blocks keep in step with the main chain, so a reorganization turns a transaction back into an unconfirmed one
+// by itself, code keeping its own records, such as the node's broadcasts, is told through a disconnect hook
+type WalletBalance struct {
+	Confirmed   int `json:"confirmed"`   // mined and spendable coins, minus the ones a pool transaction spends
+	Unconfirmed int `json:"unconfirmed"` // the outputs of pool transactions no other pool transaction spends
+	Immature    int `json:"immature"`    // coinbase outputs with fewer confirmations than the chain's coinbase maturity
+}
+
+type WalletTransaction struct {
+	TxID          []byte
+	Height        int // -1 while the transaction waits in the pool
+	Confirmations int
+	Coinbase      bool
+}
+
+// called with every block taken off the main chain, after the change is committed
+type DisconnectHook func(chainID string, block *Block)
+
+var disconnectHooks struct {
+	sync.Mutex
+	hooks []DisconnectHook
+}
+
+// run a function every time a block is disconnected, on any chain of the process
+func RegisterDisconnectHook(hook DisconnectHook) {
+	disconnectHooks.Lock()
+	defer disconnectHooks.Unlock()
+
+	disconnectHooks.hooks = append(disconnectHooks.hooks, hook)
+}
+
+func runDisconnectHooks(chainID string, blocks []*Block) {
+	disconnectHooks.Lock()
+	hooks := slices.Clone(disconnectHooks.hooks)
+	disconnectHooks.Unlock()
+
+	for _, block := range blocks {
+		for _, hook := range hooks {
+			hook(chainID, block)
+		}
+	}
+}
+
+// called with every block added to the main chain, oldest first, after the change is committed
+type ConnectHook func(chainID string, block *Block)
+
+var connectHooks struct {
+	sync.Mutex
+	hooks []ConnectHook
+}
+
+// run a function every time a block is connected, on any chain of the process
+func RegisterConnectHook(hook ConnectHook) {
+	connectHooks.Lock()
+	defer connectHooks.Unlock()
+
+	connectHooks.hooks = append(connectHooks.hooks, hook)
+}
+
+func runConnectHooks(chainID string, blocks []*Block) {
+	connectHooks.Lock()
+	hooks := slices.Clone(connectHooks.hooks)
+	connectHooks.Unlock()
+
+	for _, block := range blocks {
+		for _, hook := range hooks {
+			hook(chainID, block)
+		}
+	}
+}
+
+// the number of blocks on top of and including the main chain block at the given height
+func (chain *BlockChain) Confirmations(height int) int {
+	return chain.GetStats().Height - height + 1
+}
+
+// the IDs of the coinbase transactions paying the key that are not mature yet, hex encoded
+// only the blocks within the maturity window are read
+func (chain *BlockChain) immatureCoinbases(publicKeyHash []byte) map[string]bool {
+	maturity := chain.Params.CoinbaseMaturity
+	if maturity == 0 {
+		return nil
+	}
+
+	immature := make(map[string]bool)
+	for _, atx := range chain.AddressTransactions(publicKeyHash) {
+		if chain.Confirmations(atx.Height) >= maturity {
+			continue
+		}
+
+		tx, err := chain.transactionAt(atx.Height, atx.TxID)
+		Handle(err)
+		if tx.isCoinbase() {
+			immature[hex.EncodeToString(tx.ID)] = true
+		}
+	}
+
+	return immature
+}
+
+// split the coins of a key by whether they can be spent now, the pool's transactions count as unconfirmed
+func (chain *BlockChain) WalletBalance(publicKeyHash []byte, pool map[string]Transaction) WalletBalance {
+	var balance WalletBalance
+
+	spent := make(map[string]bool)
+	for _, tx := range pool {
+		for _, in := range tx.Inputs {
+			spent[outpoint(in)] = true
+		}
+	}
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	for _, coin := range UTXOSet.FindCoins(publicKeyHash) {
+		switch {
+		case coin.Immature:
+			balance.Immature += coin.Value
+		case !spent[coin.Outpoint()]:
+			balance.Confirmed += coin.Value
+		}
+	}
+
+	for id, tx := range pool {
+		txID, err := hex.DecodeString(id)
+		Handle(err)
+
+		for i, out := range tx.Outputs {
+			if out.isLockedWithKey(publicKeyHash) && !spent[outpoint(TransactionInput{ID: txID, Output: i})] {
+				balance.Unconfirmed += out.Value
+			}
+		}
+	}
+
+	return balance
+}
+
+// list the transactions paying or paid by a key with their confirmations, the pool's first and then newest first
+func (chain *BlockChain) WalletTransactions(publicKeyHash []byte, pool map[string]Transaction) []WalletTransaction {
+	var txs []WalletTransaction
+
+	var pending []string
+	for id, tx := range pool {
+		if tx.involves(publicKeyHash) {
+			pending = append(pending, id)
+		}
+	}
+	slices.Sort(pending)
+	for _, id := range pending {
+		tx := pool[id]
+		txs = append(txs, WalletTransaction{TxID: tx.ID, Height: -1})
+	}
+
+	height := chain.GetStats().Height
+	confirmed := chain.AddressTransactions(publicKeyHash)
+	for i := len(confirmed) - 1; i >= 0; i-- {
+		atx := confirmed[i]
+
+		tx, err := chain.transactionAt(atx.Height, atx.TxID)
+		Handle(err)
+		txs = append(txs, WalletTransaction{atx.TxID, atx.Height, height - atx.Height + 1, tx.isCoinbase()})
+	}
+
+	return txs
+}
+
+// the transaction pays the key or spends one of its coins
+func (tx *Transaction) involves(publicKeyHash []byte) bool {
+	for _, out := range tx.Outputs {
+		if out.isLockedWithKey(publicKeyHash) {
+			return true
+		}
+	}
+	if tx.isCoinbase() {
+		return false
+	}
+	for _, in := range tx.Inputs {
+		if bytes.Equal(wallet.PublicKeyHash(in.PublicKey), publicKeyHash) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,791 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"log"
+
+	"github.com/dgraph-io/badger"
+)
+
+// every piece of state derived from the blocks is kept up to date by connecting and disconnecting blocks
+// a block's changes are written in the same badger transaction, so the indexes never disagree with each other
+var (
+	AddressPrefix = []byte("addr-")   // address index: public key hash + height + transaction ID
+	BalancePrefix = []byte("bal-")    // balance cache: public key hash -> spendable tokens
+	UndoPrefix    = []byte("undo-")   // undo data: block hash -> the outputs the block spent
+	HeightPrefix  = []byte("height-") // main chain index: height -> block hash
+	statsKey      = []byte("stats")
+)
+
+// the version of the derived indexes, raised whenever an index is added or changes, so databases indexed by an
+// older version are rebuilt when they are opened
+const indexVersion = 5
+
+// running totals over the main chain
+type ChainStats struct {
+	TipHash      []byte // the block the stats were last updated for
+	IndexVersion int    // the version of the indexes the stats belong to
+	Height       int
+	Transactions int
+	UTXOs        int // unspent outputs, data outputs excluded
+	Supply       int // tokens held by unspent outputs
+}
+
+// an output a block spent, kept to put it back when the block is disconnected
+type spentOutput struct {
+	TxID   []byte
+	Index  int
+	Output TransactionOutput
+}
+
+type blockUndo struct {
+	Spent     []spentOutput
+	Names     []nameUndo
+	Addresses []addressInfoUndo
+}
+
+// an entry of the address index
+type AddressTransaction struct {
+	Height int
+	TxID   []byte
+}
+
+func encodeGob(data any) []byte {
+	var buffer bytes.Buffer
+
+	encode := gob.NewEncoder(&buffer)
+	err := encode.Encode(data)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+func heightKey(height int) []byte {
+	return binary.BigEndian.AppendUint64(slicesCopy(HeightPrefix), uint64(height))
+}
+
+func addressKey(publicKeyHash []byte, height int, txID []byte) []byte {
+	key := append(slicesCopy(AddressPrefix), publicKeyHash...)
+	key = binary.BigEndian.AppendUint64(key, uint64(height))
+	return append(key, txID...)
+}
+
+func balanceKey(publicKeyHash []byte) []byte {
+	return append(slicesCopy(BalancePrefix), publicKeyHash...)
+}
+
+func utxoKey(txID []byte) []byte {
+	return append(slicesCopy(UTXOPrefix), txID...)
+}
+
+// the prefixes are package level slices, appending to them directly could share their backing array
+func slicesCopy(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// read a value inside a transaction, returning nil when the key does not exist
+func getValue(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+// whether a key exists inside a transaction, for keys whose value is empty
+func hasKey(txn *badger.Txn, key []byte) (bool, error) {
+	_, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func getStats(txn *badger.Txn) (ChainStats, error) {
+	var stats ChainStats
+
+	data, err := getValue(txn, statsKey)
+	if err != nil || data == nil {
+		return stats, err
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&stats)
+	return stats, err
+}
+
+func getBlock(txn *badger.Txn, hash []byte) (*Block, error) {
+	data, err := getValue(txn, hash)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("missing block %x", hash)
+	}
+
+	return deserializeBlock(data)
+}
+
+func addBalance(txn *badger.Txn, publicKeyHash []byte, delta int) error {
+	return addCounter(txn, balanceKey(publicKeyHash), delta)
+}
+
+// add to a signed counter stored under a key, counters at zero are deleted
+func addCounter(txn *badger.Txn, key []byte, delta int) error {
+	data, err := getValue(txn, key)
+	if err != nil {
+		return err
+	}
+
+	balance := int64(0)
+	if data != nil {
+		balance = int64(binary.BigEndian.Uint64(data))
+	}
+	balance += int64(delta)
+
+	if balance == 0 {
+		return txn.Delete(key)
+	}
+	return txn.Set(key, binary.BigEndian.AppendUint64(nil, uint64(balance)))
+}
+
+// the public key hashes a transaction touches, each listed once
+func touchedAddresses(tx *Transaction) [][]byte {
+	var hashes [][]byte
+	seen := make(map[string]bool)
+
+	add := func(publicKeyHash []byte) {
+		if !seen[string(publicKeyHash)] {
+			seen[string(publicKeyHash)] = true
+			hashes = append(hashes, publicKeyHash)
+		}
+	}
+
+	if !tx.isCoinbase() {
+		for _, in := range tx.Inputs {
+			add(wallet.PublicKeyHash(in.PublicKey))
+		}
+	}
+	for _, out := range tx.Outputs {
+		if !out.IsData() {
+			add(out.PublicKeyHash)
+		}
+	}
+
+	return hashes
+}
+
+// apply a block on top of the current state: spend its inputs, add its outputs and update every index
+// the block is checked against the state it spends from first: every input is signed by the key of the output it
+// spends, no transaction pays out more than it spends, claims hold against the bridge index and the coinbase
+// pays at most the block reward and the fees, a block failing any of it is rejected with ErrInvalidBlock
+// the caller is responsible for moving the last hash pointer
+func connectBlock(txn *badger.Txn, block *Block) error {
+	return applyBlock(txn, block, true)
+}
+
+// connect a main chain block again while the indexes are rebuilt, it was checked when it was first connected and
+// checking it again could turn it away for reasons of today, such as headers of a bridged chain tracked since
+func reconnectBlock(txn *badger.Txn, block *Block) error {
+	return applyBlock(txn, block, false)
+}
+
+func applyBlock(txn *badger.Txn, block *Block, check bool) error {
+	stats, err := getStats(txn)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stats.TipHash, block.PrevHash) {
+		return fmt.Errorf("block %x does not extend the connected tip %x", block.Hash, stats.TipHash)
+	}
+
+	params, err := getParams(txn)
+	if err != nil {
+		return err
+	}
+
+	undo := blockUndo{}
+	fees, coinbaseValue := 0, 0
+
+	for _, tx := range block.Transactions {
+		firstSpent := len(undo.Spent)
+		if !tx.isCoinbase() {
+			for _, in := range tx.Inputs {
+				spent, err := spendOutput(txn, in)
+				if err != nil {
+					return err
+				}
+				undo.Spent = append(undo.Spent, spent)
+
+				if err := addBalance(txn, spent.Output.PublicKeyHash, -spent.Output.Value); err != nil {
+					return err
+				}
+				stats.UTXOs--
+				stats.Supply -= spent.Output.Value
+			}
+		}
+
+		if check {
+			fee, err := checkSpends(txn, params.ChainID, tx, undo.Spent[firstSpent:])
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+			}
+			fees += fee
+		}
+		if tx.isCoinbase() {
+			for _, out := range tx.Outputs {
+				coinbaseValue += out.Value
+			}
+		}
+
+		created := TransactionOutputs{}
+		for outIdx, out := range tx.Outputs {
+			// data outputs can never be spent, so they are kept out of the UTXO set
+			if out.IsData() {
+				continue
+			}
+			created.Outputs = append(created.Outputs, out)
+			created.Indices = append(created.Indices, outIdx)
+
+			if err := addBalance(txn, out.PublicKeyHash, out.Value); err != nil {
+				return err
+			}
+			stats.UTXOs++
+			stats.Supply += out.Value
+		}
+		if len(created.Outputs) > 0 {
+			if err := txn.Set(utxoKey(tx.ID), created.Serialize()); err != nil {
+				return err
+			}
+		}
+
+		for _, publicKeyHash := range touchedAddresses(tx) {
+			if err := txn.Set(addressKey(publicKeyHash, block.Height, tx.ID), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		if err := indexMetadata(txn, tx, block.Height); err != nil {
+			return err
+		}
+		if err := indexName(txn, tx, block.Height, undo.Spent[firstSpent:], &undo); err != nil {
+			return err
+		}
+		if err := indexBridge(txn, tx, 1); err != nil {
+			return err
+		}
+		if err := indexAddressInfo(txn, tx, block.Height, undo.Spent[firstSpent:], &undo); err != nil {
+			return err
+		}
+	}
+
+	if check && coinbaseValue > params.Reward(block.Height)+fees {
+		return fmt.Errorf("%w: the coinbase of block %x pays %d, only %d are due", ErrInvalidBlock, block.Hash, coinbaseValue, params.Reward(block.Height)+fees)
+	}
+
+	if err := indexGovernance(txn, block, undo.Spent); err != nil {
+		return err
+	}
+
+	if err := txn.Set(append(slicesCopy(UndoPrefix), block.Hash...), encodeGob(undo)); err != nil {
+		return err
+	}
+	if err := txn.Set(heightKey(block.Height), block.Hash); err != nil {
+		return err
+	}
+
+	stats.TipHash = block.Hash
+	stats.IndexVersion = indexVersion
+	stats.Height = block.Height
+	stats.Transactions += len(block.Transactions)
+
+	return txn.Set(statsKey, encodeGob(stats))
+}
+
+// the fee a transaction leaves to the miner, given the outputs its inputs spent
+func spentFee(tx *Transaction, spent []spentOutput) int {
+	fee := 0
+	for _, s := range spent {
+		fee += s.Output.Value
+	}
+	for _, out := range tx.Outputs {
+		fee -= out.Value
+	}
+	return fee
+}
+
+// check a transaction against the outputs it spent, returning the fee it leaves to the miner
+// claims create their coins and coinbases are checked with the whole block, so neither leaves a fee
+func checkSpends(txn *badger.Txn, chainID string, tx *Transaction, spent []spentOutput) (int, error) {
+	if tx.isCoinbase() {
+		return 0, nil
+	}
+	if tx.isBridgeClaim() {
+		return 0, checkBridgeClaim(txn, chainID, tx)
+	}
+
+	// the spent outputs stand in for the transactions they came from, which is all the signatures commit to
+	previousTXs := make(map[string]Transaction)
+	for _, s := range spent {
+		id := hex.EncodeToString(s.TxID)
+		previous := previousTXs[id]
+		previous.ID = s.TxID
+		for len(previous.Outputs) <= s.Index {
+			previous.Outputs = append(previous.Outputs, TransactionOutput{})
+		}
+		previous.Outputs[s.Index] = s.Output
+		previousTXs[id] = previous
+	}
+
+	if err := CheckInputs(tx, previousTXs); err != nil {
+		return 0, err
+	}
+
+	fee := spentFee(tx, spent)
+	if fee < 0 {
+		return 0, fmt.Errorf("transaction %x pays out %d more than it spends", tx.ID, -fee)
+	}
+
+	return fee, nil
+}
+
+// remove the output an input references from the UTXO set
+func spendOutput(txn *badger.Txn, in TransactionInput) (spentOutput, error) {
+	key := utxoKey(in.ID)
+
+	data, err := getValue(txn, key)
+	if err != nil {
+		return spentOutput{}, err
+	}
+	if data == nil {
+		return spentOutput{}, fmt.Errorf("%w: output %x:%d is not unspent", ErrInvalidBlock, in.ID, in.Output)
+	}
+
+	outs := DeserializeOutputs(data)
+	remaining := TransactionOutputs{}
+	var spent *spentOutput
+
+	for i, out := range outs.Outputs {
+		if outs.index(i) == in.Output && spent == nil {
+			spent = &spentOutput{in.ID, in.Output, out}
+			continue
+		}
+		remaining.Outputs = append(remaining.Outputs, out)
+		remaining.Indices = append(remaining.Indices, outs.index(i))
+	}
+
+	if spent == nil {
+		return spentOutput{}, fmt.Errorf("%w: output %x:%d is not unspent", ErrInvalidBlock, in.ID, in.Output)
+	}
+
+	if len(remaining.Outputs) == 0 {
+		err = txn.Delete(key)
+	} else {
+		err = txn.Set(key, remaining.Serialize())
+	}
+
+	return *spent, err
+}
+
+// revert the tip block: drop its outputs, put back what it spent and unwind every index
+// the caller is responsible for moving the last hash pointer
+func disconnectBlock(txn *badger.Txn, block *Block) error {
+	stats, err := getStats(txn)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stats.TipHash, block.Hash) {
+		return fmt.Errorf("block %x is not the connected tip", block.Hash)
+	}
+
+	undoKey := append(slicesCopy(UndoPrefix), block.Hash...)
+	data, err := getValue(txn, undoKey)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("missing undo data for block %x", block.Hash)
+	}
+
+	var undo blockUndo
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo); err != nil {
+		return err
+	}
+
+	createdHere := make(map[string]bool)
+
+	for _, tx := range block.Transactions {
+		createdHere[string(tx.ID)] = true
+
+		// whatever was spent inside this block is restored below only to be dropped again, so skip it
+		data, err := getValue(txn, utxoKey(tx.ID))
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			for _, out := range DeserializeOutputs(data).Outputs {
+				if err := addBalance(txn, out.PublicKeyHash, -out.Value); err != nil {
+					return err
+				}
+				stats.UTXOs--
+				stats.Supply -= out.Value
+			}
+			if err := txn.Delete(utxoKey(tx.ID)); err != nil {
+				return err
+			}
+		}
+
+		for _, publicKeyHash := range touchedAddresses(tx) {
+			if err := txn.Delete(addressKey(publicKeyHash, block.Height, tx.ID)); err != nil {
+				return err
+			}
+		}
+
+		if err := unindexMetadata(txn, tx); err != nil {
+			return err
+		}
+		if err := indexBridge(txn, tx, -1); err != nil {
+			return err
+		}
+	}
+
+	if err := unindexNames(txn, undo.Names); err != nil {
+		return err
+	}
+	if err := unindexAddressInfo(txn, undo.Addresses); err != nil {
+		return err
+	}
+	if err := unindexGovernance(txn, block); err != nil {
+		return err
+	}
+
+	for _, spent := range undo.Spent {
+		if createdHere[string(spent.TxID)] {
+			continue
+		}
+
+		if err := restoreOutput(txn, spent); err != nil {
+			return err
+		}
+		if err := addBalance(txn, spent.Output.PublicKeyHash, spent.Output.Value); err != nil {
+			return err
+		}
+		stats.UTXOs++
+		stats.Supply += spent.Output.Value
+	}
+
+	if err := txn.Delete(undoKey); err != nil {
+		return err
+	}
+	if err := txn.Delete(heightKey(block.Height)); err != nil {
+		return err
+	}
+
+	stats.TipHash = block.PrevHash
+	stats.Height = block.Height - 1
+	stats.Transactions -= len(block.Transactions)
+
+	return txn.Set(statsKey, encodeGob(stats))
+}
+
+// put a spent output back into its transaction's UTXO entry, keeping the entry ordered by output index
+func restoreOutput(txn *badger.Txn, spent spentOutput) error {
+	key := utxoKey(spent.TxID)
+
+	outs := TransactionOutputs{}
+	data, err := getValue(txn, key)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		outs = DeserializeOutputs(data)
+	}
+
+	restored := TransactionOutputs{}
+	inserted := false
+	for i, out := range outs.Outputs {
+		if !inserted && outs.index(i) > spent.Index {
+			restored.Outputs = append(restored.Outputs, spent.Output)
+			restored.Indices = append(restored.Indices, spent.Index)
+			inserted = true
+		}
+		restored.Outputs = append(restored.Outputs, out)
+		restored.Indices = append(restored.Indices, outs.index(i))
+	}
+	if !inserted {
+		restored.Outputs = append(restored.Outputs, spent.Output)
+		restored.Indices = append(restored.Indices, spent.Index)
+	}
+
+	return txn.Set(key, restored.Serialize())
+}
+
+// connect a block that extends the current tip, storing it if needed
+func (chain *BlockChain) ConnectBlock(block *Block) error {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if !bytes.Equal(block.PrevHash, chain.LastHash) {
+			return errors.New("block does not extend the chain's tip")
+		}
+
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if err := connectBlock(txn, block); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	chain.LastHash = block.Hash
+	chain.runActivations(block.Height)
+	runConnectHooks(chain.ChainID, []*Block{block})
+
+	return nil
+}
+
+// disconnect the tip block, making its parent the new tip
+func (chain *BlockChain) DisconnectBlock() (*Block, error) {
+	var block *Block
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		var err error
+		block, err = getBlock(txn, chain.LastHash)
+		if err != nil {
+			return err
+		}
+		if len(block.PrevHash) == 0 {
+			return errors.New("the genesis block cannot be disconnected")
+		}
+
+		if err := disconnectBlock(txn, block); err != nil {
+			return err
+		}
+
+		return txn.Set([]byte("lh"), block.PrevHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chain.LastHash = block.PrevHash
+	runDisconnectHooks(chain.ChainID, []*Block{block})
+
+	return block, nil
+}
+
+// a block failing to connect because of what it holds, rather than because the database failed
+type invalidBlockError struct {
+	Hash []byte
+	Err  error
+}
+
+func (e *invalidBlockError) Error() string {
+	return fmt.Sprintf("block %x does not connect: %v", e.Hash, e.Err)
+}
+
+func (e *invalidBlockError) Unwrap() error {
+	return e.Err
+}
+
+// connect a block received from another node, telling which block was at fault when it is rejected
+func connectReceivedBlock(txn *badger.Txn, block *Block) error {
+	err := connectBlock(txn, block)
+	if errors.Is(err, ErrInvalidBlock) {
+		return &invalidBlockError{block.Hash, err}
+	}
+	return err
+}
+
+// switch the main chain over to the branch ending at newTip, if that branch reaches the main chain
+// blocks are disconnected down to the fork point and the branch is connected on top, all in the caller's transaction
+// the connected blocks, oldest first, and the disconnected blocks, newest first, are returned once the branch is
+// switched to
+func reorganize(txn *badger.Txn, tip, newTip *Block) ([]*Block, []*Block, error) {
+	branch := []*Block{newTip}
+	current := newTip
+
+	for {
+		if len(current.PrevHash) == 0 {
+			return nil, nil, errors.New("branch has a different genesis block")
+		}
+
+		forkHash, err := getValue(txn, heightKey(current.Height-1))
+		if err != nil {
+			return nil, nil, err
+		}
+		if bytes.Equal(forkHash, current.PrevHash) {
+			break
+		}
+
+		parent, err := getBlock(txn, current.PrevHash)
+		if err != nil {
+			// the branch's parents have not arrived yet, keep the block until they do
+			return nil, nil, nil
+		}
+		branch = append(branch, parent)
+		current = parent
+	}
+
+	var disconnected []*Block
+	forkHeight := current.Height - 1
+	for tip.Height > forkHeight {
+		if err := disconnectBlock(txn, tip); err != nil {
+			return nil, nil, err
+		}
+		log.Printf("disconnected block %x at height %d", tip.Hash, tip.Height)
+		disconnected = append(disconnected, tip)
+
+		parent, err := getBlock(txn, tip.PrevHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		tip = parent
+	}
+
+	var connected []*Block
+	for i := len(branch) - 1; i >= 0; i-- {
+		if err := connectReceivedBlock(txn, branch[i]); err != nil {
+			return nil, nil, err
+		}
+		connected = append(connected, branch[i])
+	}
+
+	return connected, disconnected, txn.Set([]byte("lh"), newTip.Hash)
+}
+
+// the derived indexes belong to the current tip and the current index version, i.e., they are not stale, half
+// built or missing an index added since
+func (chain *BlockChain) indexesMatchTip() bool {
+	matches := false
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		stats, err := getStats(txn)
+		matches = bytes.Equal(stats.TipHash, chain.LastHash) && stats.IndexVersion == indexVersion
+		return err
+	})
+
+	return err == nil && matches
+}
+
+// throw away every derived index and rebuild them by connecting the main chain from the genesis block
+func (chain *BlockChain) reindex() {
+	for _, prefix := range [][]byte{UTXOPrefix, AddressPrefix, BalancePrefix, UndoPrefix, HeightPrefix, MetadataPrefix, NamePrefix, AddressInfoPrefix, BridgeClaimPrefix, BridgeLockedPrefix, BridgeMintedPrefix, ProposalPrefix, VotePrefix, ParameterPrefix, SnapshotPrefix, statsKey} {
+		chain.deleteByPrefix(prefix)
+	}
+
+	var blocks []*Block
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		blocks = append(blocks, block)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			return reconnectBlock(txn, blocks[i])
+		})
+		Handle(err)
+	}
+}
+
+// fetch the cached spendable balance of a public key hash
+func (chain *BlockChain) GetBalance(publicKeyHash []byte) int {
+	balance := 0
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		data, err := getValue(txn, balanceKey(publicKeyHash))
+		if data != nil {
+			balance = int(int64(binary.BigEndian.Uint64(data)))
+		}
+		return err
+	})
+	Handle(err)
+
+	return balance
+}
+
+func (chain *BlockChain) GetStats() ChainStats {
+	var stats ChainStats
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		stats, err = getStats(txn)
+		return err
+	})
+	Handle(err)
+
+	return stats
+}
+
+// fetch the hash of the main chain's block at the given height
+func (chain *BlockChain) GetBlockHashAt(height int) ([]byte, error) {
+	var hash []byte
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		hash, err = getValue(txn, heightKey(height))
+		if err == nil && hash == nil {
+			return fmt.Errorf("no block at height %d", height)
+		}
+		return err
+	})
+
+	return hash, err
+}
+
+// list the main chain transactions that paid or were paid by a public key hash, oldest first
+func (chain *BlockChain) AddressTransactions(publicKeyHash []byte) []AddressTransaction {
+	var txs []AddressTransaction
+	prefix := append(slicesCopy(AddressPrefix), publicKeyHash...)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().KeyCopy(nil)[len(prefix):]
+			height := int(binary.BigEndian.Uint64(key[:8]))
+			txs = append(txs, AddressTransaction{height, key[8:]})
+		}
+
+		return nil
+	})
+	Handle(err)
+
+	return txs
+}
+
+// find the height of the main chain block that includes a transaction spending coins, false if none does
+// the address index of the key signing its first input is searched, so no block has to be read
+func (chain *BlockChain) ConfirmedHeight(tx *Transaction) (int, bool) {
+	if len(tx.Inputs) == 0 || tx.isCoinbase() {
+		return 0, false
+	}
+
+	for _, atx := range chain.AddressTransactions(wallet.PublicKeyHash(tx.Inputs[0].PublicKey)) {
+		if bytes.Equal(atx.TxID, tx.ID) {
+			return atx.Height, true
+		}
+	}
+
+	return 0, false
+}
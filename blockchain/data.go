@@ -0,0 +1,56 @@
+package blockchain
+
+import "encoding/binary"
+
+// data outputs carry a payload instead of an owner
+// gob includes a struct's fields in every encoding, so a new field would change the hash of every stored block;
+// the payload lives in the public key hash instead, behind a marker, much like bitcoin's OP_RETURN outputs
+const (
+	dataMarker          byte = 0x6a
+	publicKeyHashLength      = 20 // the size of a RIPEMD160 digest, the only size a real owner can have
+)
+
+// the first byte after the marker names the kind of data, so that each feature only reads its own outputs
+const (
+	DataBridgeTransfer byte = iota + 1 // coins locked or burnt to be released on another chain
+	DataBridgeClaim                    // the proof that releases coins moved from another chain
+	DataMetadata                       // key/value pairs describing the transaction, e.g. a memo or an invoice ID
+	DataName                           // the registration or renewal of a name
+	DataAnchor                         // the hash of a document, timestamped by the block including it
+	DataProposal                       // a proposal to change a governed parameter
+	DataVote                           // a vote for or against a proposal
+)
+
+// create an output that carries data, its value (if any) is taken out of circulation
+func NewDataOutput(value int, kind byte, payload []byte) *TransactionOutput {
+	data := []byte{dataMarker, kind}
+	data = binary.AppendUvarint(data, uint64(len(payload)))
+	data = append(data, payload...)
+
+	// never let a data output be mistaken for a key hash
+	for len(data) <= publicKeyHashLength {
+		data = append(data, 0)
+	}
+
+	return &TransactionOutput{value, data}
+}
+
+func (out *TransactionOutput) IsData() bool {
+	return len(out.PublicKeyHash) > publicKeyHashLength && out.PublicKeyHash[0] == dataMarker
+}
+
+// split a data output into its kind and payload
+func (out *TransactionOutput) payload() (byte, []byte) {
+	if !out.IsData() {
+		return 0, nil
+	}
+
+	kind := out.PublicKeyHash[1]
+	size, n := binary.Uvarint(out.PublicKeyHash[2:])
+	if n <= 0 || uint64(len(out.PublicKeyHash)-2-n) < size {
+		return 0, nil
+	}
+
+	start := 2 + n
+	return kind, out.PublicKeyHash[start : start+int(size)]
+}
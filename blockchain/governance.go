@@ -0,0 +1,528 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"slices"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// on-chain governance for private networks: anyone can propose a new value for a parameter, holders vote
+// with the coins they held when the proposal was mined, and a passing proposal takes effect a few blocks
+// after its voting period ends
+// like names, proposals and votes that do not hold, such as ones paying less than GovernanceFee, are ignored
+// instead of invalidating their block
+const (
+	GovernanceVotingPeriod    = 20 // the number of blocks after a proposal during which votes count
+	GovernanceActivationDelay = 5  // the number of blocks between the end of the vote and the new value
+	GovernanceQuorum          = 10 // the percentage of the snapshot supply that has to take part
+	GovernanceFee             = 1  // left to the miner by every proposal and vote
+)
+
+// the parameters proposals can change
+const (
+	ParamMaxBlockSize = "maxblocksize" // the largest total size of the transactions a miner packs into a block
+	ParamMinFee       = "minfee"       // the fee each transaction must pay to be mined
+)
+
+var governedParameters = map[string]bool{ParamMaxBlockSize: true, ParamMinFee: true}
+
+// governance indexes
+var (
+	ProposalPrefix  = []byte("prop-")  // proposal ID -> the proposal's record
+	VotePrefix      = []byte("vote-")  // proposal ID + height + transaction ID -> the vote
+	ParameterPrefix = []byte("param-") // parameter + 0x00 + activation height -> the value activated
+	SnapshotPrefix  = []byte("snap-")  // proposal ID -> the balances the proposal's votes are weighed with
+)
+
+// the payloads of proposal and vote outputs
+type Proposal struct {
+	Title     string
+	Parameter string
+	Value     int
+}
+
+type Vote struct {
+	ProposalID []byte
+	Yes        bool
+}
+
+type ProposalRecord struct {
+	ID       []byte
+	Proposal Proposal
+	Proposer []byte // the public key hash of the proposer
+	Height   int    // the block the proposal was mined in, balances are weighed as of this block
+}
+
+// the last block votes count in
+func (r ProposalRecord) VotingEnds() int {
+	return r.Height + GovernanceVotingPeriod
+}
+
+// the block the proposal takes effect at if it passes
+func (r ProposalRecord) ActivatesAt() int {
+	return r.VotingEnds() + GovernanceActivationDelay
+}
+
+type voteRecord struct {
+	Voter  []byte
+	Yes    bool
+	Height int
+}
+
+type ProposalTally struct {
+	Proposal ProposalRecord
+	Yes      int // the coins voting for the proposal
+	No       int // the coins voting against it
+	Voters   int
+	Supply   int  // the coins in circulation at the snapshot
+	Closed   bool // the voting period is over
+	Passed   bool // the vote is over, reached the quorum and more coins voted for it than against it
+}
+
+func (t ProposalTally) quorum() bool {
+	return (t.Yes+t.No)*100 >= t.Supply*GovernanceQuorum
+}
+
+// called once a passing proposal takes effect, with the chain and the parameter's new value
+type ActivationHook func(chainID, parameter string, value int)
+
+var activationHooks struct {
+	sync.Mutex
+	hooks []ActivationHook
+}
+
+// run a function every time a proposal takes effect, on any chain of the process
+func RegisterActivationHook(hook ActivationHook) {
+	activationHooks.Lock()
+	defer activationHooks.Unlock()
+
+	activationHooks.hooks = append(activationHooks.hooks, hook)
+}
+
+func runActivationHooks(chainID, parameter string, value int) {
+	activationHooks.Lock()
+	hooks := slices.Clone(activationHooks.hooks)
+	activationHooks.Unlock()
+
+	for _, hook := range hooks {
+		hook(chainID, parameter, value)
+	}
+}
+
+func proposalKey(id []byte) []byte {
+	return append(slicesCopy(ProposalPrefix), id...)
+}
+
+func voteKey(proposalID []byte, height int, txID []byte) []byte {
+	k := append(slicesCopy(VotePrefix), proposalID...)
+	k = binary.BigEndian.AppendUint64(k, uint64(height))
+	return append(k, txID...)
+}
+
+func snapshotKey(proposalID []byte) []byte {
+	return append(slicesCopy(SnapshotPrefix), proposalID...)
+}
+
+func parameterKey(parameter string, height int) []byte {
+	k := append(slicesCopy(ParameterPrefix), parameter...)
+	k = append(k, 0)
+	return binary.BigEndian.AppendUint64(k, uint64(height))
+}
+
+// read the governance payload of a transaction, if it carries one
+func (tx *Transaction) governance() (any, bool) {
+	if len(tx.Inputs) == 0 || tx.isCoinbase() {
+		return nil, false
+	}
+
+	for _, out := range tx.Outputs {
+		kind, payload := out.payload()
+
+		switch kind {
+		case DataProposal:
+			var proposal Proposal
+			if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&proposal); err != nil {
+				return nil, false
+			}
+			return proposal, true
+		case DataVote:
+			var vote Vote
+			if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&vote); err != nil {
+				return nil, false
+			}
+			return vote, true
+		}
+	}
+
+	return nil, false
+}
+
+func getProposal(txn *badger.Txn, id []byte) (*ProposalRecord, error) {
+	data, err := getValue(txn, proposalKey(id))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var record ProposalRecord
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// index the proposals and votes of a connected block, then activate the proposals whose time has come
+// spent holds the outputs the block's inputs spent, in the order of its transactions
+// it runs once the rest of the block is connected, so the balance index holds the balances proposals are weighed with
+func indexGovernance(txn *badger.Txn, block *Block, spent []spentOutput) error {
+	var snapshot []byte
+	for _, tx := range block.Transactions {
+		var txSpent []spentOutput
+		if !tx.isCoinbase() {
+			txSpent, spent = spent[:len(tx.Inputs)], spent[len(tx.Inputs):]
+		}
+
+		payload, ok := tx.governance()
+		if !ok || spentFee(tx, txSpent) < GovernanceFee {
+			continue
+		}
+
+		voter := wallet.PublicKeyHash(tx.Inputs[0].PublicKey)
+
+		switch payload := payload.(type) {
+		case Proposal:
+			if !governedParameters[payload.Parameter] || payload.Value < 0 {
+				continue
+			}
+
+			if snapshot == nil {
+				balances, err := currentBalances(txn)
+				if err != nil {
+					return err
+				}
+				snapshot = encodeGob(balances)
+			}
+
+			record := ProposalRecord{tx.ID, payload, voter, block.Height}
+			if err := txn.Set(proposalKey(tx.ID), encodeGob(record)); err != nil {
+				return err
+			}
+			if err := txn.Set(snapshotKey(tx.ID), snapshot); err != nil {
+				return err
+			}
+		case Vote:
+			proposal, err := getProposal(txn, payload.ProposalID)
+			if err != nil {
+				return err
+			}
+			if proposal == nil || block.Height <= proposal.Height || block.Height > proposal.VotingEnds() {
+				continue
+			}
+
+			vote := voteRecord{voter, payload.Yes, block.Height}
+			if err := txn.Set(voteKey(payload.ProposalID, block.Height, tx.ID), encodeGob(vote)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return activateProposals(txn, block.Height)
+}
+
+// record the new value of every parameter whose passing proposal takes effect at this height
+func activateProposals(txn *badger.Txn, height int) error {
+	proposals, err := listProposals(txn)
+	if err != nil {
+		return err
+	}
+
+	for _, proposal := range proposals {
+		if proposal.ActivatesAt() != height {
+			continue
+		}
+
+		tally, err := tallyProposal(txn, proposal, height)
+		if err != nil {
+			return err
+		}
+		if !tally.Passed {
+			continue
+		}
+
+		value := binary.BigEndian.AppendUint64(nil, uint64(proposal.Proposal.Value))
+		if err := txn.Set(parameterKey(proposal.Proposal.Parameter, height), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// drop the governance entries a disconnected block added
+func unindexGovernance(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		payload, ok := tx.governance()
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch payload := payload.(type) {
+		case Proposal:
+			if err = txn.Delete(proposalKey(tx.ID)); err == nil {
+				err = txn.Delete(snapshotKey(tx.ID))
+			}
+		case Vote:
+			err = txn.Delete(voteKey(payload.ProposalID, block.Height, tx.ID))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for parameter := range governedParameters {
+		if err := txn.Delete(parameterKey(parameter, block.Height)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func listProposals(txn *badger.Txn) ([]ProposalRecord, error) {
+	var proposals []ProposalRecord
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(ProposalPrefix); it.ValidForPrefix(ProposalPrefix); it.Next() {
+		data, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var record ProposalRecord
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, record)
+	}
+
+	return proposals, nil
+}
+
+// the balance of every address as the balance index holds it
+func currentBalances(txn *badger.Txn) (map[string]int, error) {
+	balances := make(map[string]int)
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(BalancePrefix); it.ValidForPrefix(BalancePrefix); it.Next() {
+		data, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		publicKeyHash := it.Item().KeyCopy(nil)[len(BalancePrefix):]
+		balances[string(publicKeyHash)] = int(int64(binary.BigEndian.Uint64(data)))
+	}
+
+	return balances, nil
+}
+
+// the balances taken when a proposal was mined
+func getSnapshot(txn *badger.Txn, proposalID []byte) (map[string]int, error) {
+	data, err := getValue(txn, snapshotKey(proposalID))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no balance snapshot for proposal %x", proposalID)
+	}
+
+	var balances map[string]int
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&balances)
+	return balances, err
+}
+
+// count the votes of a proposal as of the given height, each voter's latest vote replaces the earlier ones
+func tallyProposal(txn *badger.Txn, proposal ProposalRecord, height int) (*ProposalTally, error) {
+	balances, err := getSnapshot(txn, proposal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tally := ProposalTally{Proposal: proposal, Closed: height > proposal.VotingEnds()}
+	for _, balance := range balances {
+		tally.Supply += balance
+	}
+
+	votes := make(map[string]bool)
+
+	prefix := append(slicesCopy(VotePrefix), proposal.ID...)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	// keys are ordered by height, so later votes overwrite earlier ones
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		data, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var vote voteRecord
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&vote); err != nil {
+			return nil, err
+		}
+		if vote.Height <= height {
+			votes[string(vote.Voter)] = vote.Yes
+		}
+	}
+
+	for voter, yes := range votes {
+		if yes {
+			tally.Yes += balances[voter]
+		} else {
+			tally.No += balances[voter]
+		}
+	}
+	tally.Voters = len(votes)
+	tally.Passed = tally.Closed && tally.quorum() && tally.Yes > tally.No
+
+	return &tally, nil
+}
+
+// run the hooks of the proposals that took effect in the block at the given height
+func (chain *BlockChain) runActivations(height int) {
+	var activated []string
+	values := make(map[string]int)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		for parameter := range governedParameters {
+			data, err := getValue(txn, parameterKey(parameter, height))
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				activated = append(activated, parameter)
+				values[parameter] = int(binary.BigEndian.Uint64(data))
+			}
+		}
+		return nil
+	})
+	Handle(err)
+
+	slices.Sort(activated)
+	for _, parameter := range activated {
+		runActivationHooks(chain.ChainID, parameter, values[parameter])
+	}
+}
+
+// the value of a governed parameter at the tip, or the fallback if no proposal ever changed it
+func (chain *BlockChain) Parameter(parameter string, fallback int) int {
+	value := fallback
+
+	prefix := append(slicesCopy(ParameterPrefix), parameter...)
+	prefix = append(prefix, 0)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		// activation heights are big endian, so the last entry is the latest
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			data, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			value = int(binary.BigEndian.Uint64(data))
+		}
+		return nil
+	})
+	Handle(err)
+
+	return value
+}
+
+// create a transaction proposing a new value for a parameter
+func NewProposalTransaction(w *wallet.Wallet, title, parameter string, value int, UTXO *UTXOSet) (*Transaction, error) {
+	if !governedParameters[parameter] {
+		return nil, fmt.Errorf("parameter %q cannot be governed", parameter)
+	}
+	if value < 0 {
+		return nil, errors.New("parameters cannot be negative")
+	}
+
+	outputs := []TransactionOutput{*NewDataOutput(0, DataProposal, encodeGob(Proposal{title, parameter, value}))}
+
+	return newSpendingTransaction(w, 0, GovernanceFee, outputs, UTXO), nil
+}
+
+// create a transaction voting for or against a proposal still open
+func (chain *BlockChain) NewVoteTransaction(w *wallet.Wallet, proposalID []byte, yes bool, UTXO *UTXOSet) (*Transaction, error) {
+	tally, err := chain.Tally(proposalID)
+	if err != nil {
+		return nil, err
+	}
+	if chain.GetStats().Height+1 > tally.Proposal.VotingEnds() {
+		return nil, fmt.Errorf("voting on proposal %x ended at block %d", proposalID, tally.Proposal.VotingEnds())
+	}
+
+	outputs := []TransactionOutput{*NewDataOutput(0, DataVote, encodeGob(Vote{proposalID, yes}))}
+
+	return newSpendingTransaction(w, 0, GovernanceFee, outputs, UTXO), nil
+}
+
+// every proposal ever mined on the main chain, oldest first
+func (chain *BlockChain) Proposals() []ProposalRecord {
+	var proposals []ProposalRecord
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		proposals, err = listProposals(txn)
+		return err
+	})
+	Handle(err)
+
+	slices.SortFunc(proposals, func(a, b ProposalRecord) int {
+		if a.Height != b.Height {
+			return a.Height - b.Height
+		}
+		return bytes.Compare(a.ID, b.ID)
+	})
+
+	return proposals
+}
+
+// count the votes of a proposal as of the tip
+func (chain *BlockChain) Tally(proposalID []byte) (*ProposalTally, error) {
+	var tally *ProposalTally
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		proposal, err := getProposal(txn, proposalID)
+		if err != nil {
+			return err
+		}
+		if proposal == nil {
+			return fmt.Errorf("proposal %s does not exist", hex.EncodeToString(proposalID))
+		}
+
+		stats, err := getStats(txn)
+		if err != nil {
+			return err
+		}
+
+		tally, err = tallyProposal(txn, *proposal, stats.Height)
+		return err
+	})
+
+	return tally, err
+}
@@ -0,0 +1,325 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"slices"
+
+	"github.com/dgraph-io/badger"
+)
+
+// how a pool transaction ranks for inclusion in a block
+// a transaction can only be mined together with its unconfirmed ancestors, so it is ranked by the fee rate of
+// that whole package: a child paying a high fee pulls its low-fee parents into the block with it
+type MempoolEntry struct {
+	ID          []byte
+	Size        int      // virtual size, see Transaction.VirtualSize
+	Fee         int      // what the inputs bring in and the outputs do not spend
+	Ancestors   []string // the IDs of the unconfirmed transactions it depends on, parents last
+	PackageSize int      // size of the transaction and its ancestors
+	PackageFee  int      // fee of the transaction and its ancestors
+}
+
+// fee per virtual byte of the transaction on its own
+func (e MempoolEntry) FeeRate() float64 {
+	return float64(e.Fee) / float64(e.Size)
+}
+
+// fee per virtual byte of the transaction together with its ancestors
+func (e MempoolEntry) PackageFeeRate() float64 {
+	return float64(e.PackageFee) / float64(e.PackageSize)
+}
+
+func (e MempoolEntry) String() string {
+	return fmt.Sprintf("%x size %d fee %d (%.4f/B), package of %d size %d fee %d (%.4f/B)",
+		e.ID, e.Size, e.Fee, e.FeeRate(), len(e.Ancestors)+1, e.PackageSize, e.PackageFee, e.PackageFeeRate())
+}
+
+// the fee left by a transaction, the spent outputs are looked up in the pool first and in the chain after
+func (chain *BlockChain) TransactionFee(tx *Transaction, pool map[string]Transaction) (int, error) {
+	// new coins are not paid for by anyone
+	if tx.isCoinbase() || tx.isBridgeClaim() {
+		return 0, nil
+	}
+
+	previousTXs, err := chain.previousTransactions(tx, pool)
+	if err != nil {
+		return 0, err
+	}
+
+	fee := 0
+	for _, in := range tx.Inputs {
+		fee += previousTXs[hex.EncodeToString(in.ID)].Outputs[in.Output].Value
+	}
+	for _, out := range tx.Outputs {
+		fee -= out.Value
+	}
+
+	if fee < 0 {
+		return 0, fmt.Errorf("transaction %x spends more than its inputs hold", tx.ID)
+	}
+
+	return fee, nil
+}
+
+// a pool being assembled into a block, valid transactions only
+type mempool struct {
+	txs     map[string]Transaction
+	fees    map[string]int
+	sizes   map[string]int
+	invalid map[string]bool
+}
+
+// verify every transaction of the pool, a transaction is only kept if its unconfirmed ancestors are kept too
+func (chain *BlockChain) newMempool(pool map[string]Transaction) *mempool {
+	m := &mempool{make(map[string]Transaction), make(map[string]int), make(map[string]int), make(map[string]bool)}
+
+	var check func(id string) bool
+	check = func(id string) bool {
+		if _, ok := m.txs[id]; ok {
+			return true
+		}
+		if m.invalid[id] {
+			return false
+		}
+
+		// mark the transaction first, so a cycle of transactions spending each other cannot recurse forever
+		m.invalid[id] = true
+
+		tx := pool[id]
+		for _, in := range tx.Inputs {
+			parent := hex.EncodeToString(in.ID)
+			if _, ok := pool[parent]; ok {
+				if !check(parent) {
+					return false
+				}
+			} else if !chain.isUnspent(in) {
+				// the output was spent by a block since the transaction arrived
+				return false
+			}
+		}
+
+		if !chain.VerifyPoolTransaction(&tx, pool) {
+			return false
+		}
+
+		fee, err := chain.TransactionFee(&tx, pool)
+		if err != nil {
+			return false
+		}
+
+		delete(m.invalid, id)
+		m.txs[id] = tx
+		m.fees[id] = fee
+		m.sizes[id] = tx.VirtualSize()
+
+		return true
+	}
+
+	for id := range pool {
+		check(id)
+	}
+
+	return m
+}
+
+// the ancestors of a transaction still in the pool and not in exclude, ordered so parents come before children
+func (m *mempool) ancestors(id string, exclude map[string]bool) []string {
+	var order []string
+	seen := make(map[string]bool)
+
+	var visit func(id string)
+	visit = func(id string) {
+		for _, in := range m.txs[id].Inputs {
+			parent := hex.EncodeToString(in.ID)
+			if _, ok := m.txs[parent]; !ok || exclude[parent] || seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			visit(parent)
+			order = append(order, parent)
+		}
+	}
+	visit(id)
+
+	return order
+}
+
+func (m *mempool) entry(id string, exclude map[string]bool) MempoolEntry {
+	tx := m.txs[id]
+	entry := MempoolEntry{ID: tx.ID, Size: m.sizes[id], Fee: m.fees[id], Ancestors: m.ancestors(id, exclude)}
+
+	entry.PackageSize, entry.PackageFee = entry.Size, entry.Fee
+	for _, ancestor := range entry.Ancestors {
+		entry.PackageSize += m.sizes[ancestor]
+		entry.PackageFee += m.fees[ancestor]
+	}
+
+	return entry
+}
+
+// describe every valid transaction of the pool, the best paying packages first
+func (chain *BlockChain) MempoolEntries(pool map[string]Transaction) []MempoolEntry {
+	m := chain.newMempool(pool)
+
+	var entries []MempoolEntry
+	for id := range m.txs {
+		entries = append(entries, m.entry(id, nil))
+	}
+	slices.SortFunc(entries, compareEntries)
+
+	return entries
+}
+
+// the better paying package comes first, ties are broken by ID so the order is stable
+func compareEntries(a, b MempoolEntry) int {
+	// compare a.PackageFee/a.PackageSize with b.PackageFee/b.PackageSize without rounding
+	left, right := a.PackageFee*b.PackageSize, b.PackageFee*a.PackageSize
+	if left != right {
+		return right - left
+	}
+	return slices.Compare(a.ID, b.ID)
+}
+
+// pick the transactions of the pool to mine in the next block, at most maxSize virtual bytes of them
+// the package with the best fee rate goes in first, parents before children, then the remaining packages
+// are ranked again without the transactions already picked, until nothing else fits
+// packages paying less than minFee for each of their transactions are left in the pool
+func (chain *BlockChain) SelectTransactions(pool map[string]Transaction, maxSize, minFee int) []*Transaction {
+	m := chain.newMempool(pool)
+
+	var block []*Transaction
+	picked := make(map[string]bool)
+	spent := make(map[string]bool) // the outputs spent by the picked transactions
+	size := 0
+
+	for {
+		var best *MempoolEntry
+
+		for id := range m.txs {
+			if picked[id] {
+				continue
+			}
+
+			entry := m.entry(id, picked)
+			if size+entry.PackageSize > maxSize || entry.PackageFee < minFee*(len(entry.Ancestors)+1) {
+				continue
+			}
+			if m.conflicts(append(entry.Ancestors, id), spent) {
+				continue
+			}
+
+			if best == nil || compareEntries(entry, *best) < 0 {
+				best = &entry
+			}
+		}
+
+		if best == nil {
+			break
+		}
+
+		for _, id := range append(best.Ancestors, hex.EncodeToString(best.ID)) {
+			tx := m.txs[id]
+			block = append(block, &tx)
+			picked[id] = true
+			for _, in := range tx.Inputs {
+				spent[outpoint(in)] = true
+			}
+		}
+		size += best.PackageSize
+	}
+
+	return block
+}
+
+// check whether a package spends an output already spent by the block or twice by itself
+func (m *mempool) conflicts(ids []string, spent map[string]bool) bool {
+	own := make(map[string]bool)
+
+	for _, id := range ids {
+		for _, in := range m.txs[id].Inputs {
+			key := outpoint(in)
+			if spent[key] || own[key] {
+				return true
+			}
+			own[key] = true
+		}
+	}
+
+	return false
+}
+
+// check whether the output an input refers to is still in the UTXO set
+func (chain *BlockChain) isUnspent(in TransactionInput) bool {
+	unspent := false
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		data, err := getValue(txn, utxoKey(in.ID))
+		if err != nil || data == nil {
+			return err
+		}
+
+		outs := DeserializeOutputs(data)
+		for i := range outs.Outputs {
+			if outs.index(i) == in.Output {
+				unspent = true
+			}
+		}
+
+		return nil
+	})
+	Handle(err)
+
+	return unspent
+}
+
+// check whether an unconfirmed transaction can no longer be mined because an output it spends is gone
+// outputs of transactions waiting in the pool still count as available
+func (chain *BlockChain) Conflicted(tx *Transaction, pool map[string]Transaction) bool {
+	for _, in := range tx.Inputs {
+		if _, ok := pool[hex.EncodeToString(in.ID)]; ok {
+			continue
+		}
+		if !chain.isUnspent(in) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func outpoint(in TransactionInput) string {
+	return fmt.Sprintf("%x:%d", in.ID, in.Output)
+}
+
+// create a transaction spending the wallet's outputs of an unconfirmed parent back to the wallet with a fee
+// a parent stuck in the pool for paying too little gets mined as soon as the fee of the pair is worth it
+func NewChildPaysForParent(w *wallet.Wallet, parent *Transaction, fee int) (*Transaction, error) {
+	publicKeyHash := wallet.PublicKeyHash(w.PublicKey)
+
+	var inputs []TransactionInput
+	acc := 0
+	for outIdx, out := range parent.Outputs {
+		if out.isLockedWithKey(publicKeyHash) {
+			inputs = append(inputs, TransactionInput{parent.ID, outIdx, nil, w.PublicKey})
+			acc += out.Value
+		}
+	}
+
+	if len(inputs) == 0 {
+		return nil, errors.New("the parent pays nothing to the wallet")
+	}
+	if acc <= fee {
+		return nil, fmt.Errorf("the parent pays %d to the wallet, which does not cover a fee of %d", acc, fee)
+	}
+
+	outputs := []TransactionOutput{*NewTransactionOutput(acc-fee, string(w.Address()))}
+
+	tx := Transaction{nil, inputs, outputs}
+	tx.ID = tx.hash()
+	tx.sign(w.PrivateKey, map[string]Transaction{hex.EncodeToString(parent.ID): *parent})
+
+	return &tx, nil
+}
@@ -1,6 +1,10 @@
 package blockchain
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+	"slices"
+)
 
 type MerkleTree struct {
 	RootNode *MerkleNode
@@ -45,7 +49,12 @@ func newMerkleTree(data [][]byte) *MerkleTree {
 		nodes = append(nodes, *NewMerkleNode(nil, nil, entry))
 	}
 
-	for range len(data) / 2 {
+	for len(nodes) > 1 {
+		// every level above the tips has to be balanced the same way
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		var level []MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 {
@@ -60,3 +69,67 @@ func newMerkleTree(data [][]byte) *MerkleTree {
 
 	return &tree
 }
+
+// a single step of a merkle proof: the sibling's hash and the side it sits on
+type MerkleStep struct {
+	Hash []byte
+	Left bool // the sibling is the left child, i.e., it comes first when hashing
+}
+
+// build the path of sibling hashes proving that data[index] is part of the tree
+func newMerkleProof(data [][]byte, index int) []MerkleStep {
+	var proof []MerkleStep
+	var hashes [][]byte
+
+	if len(data)%2 != 0 {
+		data = append(data, data[len(data)-1])
+	}
+
+	for _, entry := range data {
+		hashes = append(hashes, NewMerkleNode(nil, nil, entry).Data)
+	}
+
+	for len(hashes) > 1 {
+		if len(hashes)%2 != 0 {
+			hashes = append(hashes, hashes[len(hashes)-1])
+		}
+
+		// the sibling of an even index is on its right, the sibling of an odd index on its left
+		if index%2 == 0 {
+			proof = append(proof, MerkleStep{hashes[index+1], false})
+		} else {
+			proof = append(proof, MerkleStep{hashes[index-1], true})
+		}
+
+		var level [][]byte
+		for j := 0; j < len(hashes); j += 2 {
+			hash := sha256.Sum256(append(slices.Clone(hashes[j]), hashes[j+1]...))
+			level = append(level, hash[:])
+		}
+
+		hashes = level
+		index /= 2
+	}
+
+	return proof
+}
+
+// recompute the root from a leaf and its proof, without needing the rest of the tree
+func VerifyMerkleProof(leaf []byte, proof []MerkleStep, root []byte) bool {
+	hash := sha256.Sum256(leaf)
+	current := hash[:]
+
+	for _, step := range proof {
+		var combined []byte
+		if step.Left {
+			combined = append(slices.Clone(step.Hash), current...)
+		} else {
+			combined = append(slices.Clone(current), step.Hash...)
+		}
+
+		hash = sha256.Sum256(combined)
+		current = hash[:]
+	}
+
+	return bytes.Equal(current, root)
+}
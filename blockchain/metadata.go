@@ -0,0 +1,304 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"slices"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// transactions can carry a few key/value pairs (a memo, an invoice ID, ...) in a metadata data output
+// every pair is indexed when the block is connected, so payments can be found by their reference
+const (
+	maxMetadataEntries = 8
+	maxMetadataSize    = 256 // the total size of keys and values, metadata is meant to be small
+)
+
+// metadata index: key + 0x00 + value + 0x00 + transaction ID -> block height
+var MetadataPrefix = []byte("meta-")
+
+type MetadataEntry struct {
+	Key   string
+	Value string
+}
+
+// a transaction found by SearchTransactions
+type MetadataMatch struct {
+	TxID     []byte
+	Height   int
+	Metadata map[string]string
+}
+
+// check that metadata fits the limits and cannot break the index's separators
+// every transaction is held to it, so metadata coming from other nodes is as well formed as the one built here
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataEntries {
+		return fmt.Errorf("at most %d metadata entries are allowed", maxMetadataEntries)
+	}
+
+	size := 0
+	for key, value := range metadata {
+		if key == "" {
+			return errors.New("metadata keys must not be empty")
+		}
+		if !indexableEntry(key, value) {
+			return fmt.Errorf("metadata entry %q contains a reserved character", key)
+		}
+		size += len(key) + len(value)
+	}
+
+	if size > maxMetadataSize {
+		return fmt.Errorf("metadata is %d bytes, at most %d are allowed", size, maxMetadataSize)
+	}
+
+	return nil
+}
+
+func indexableEntry(key, value string) bool {
+	return key != "" && !strings.ContainsAny(key, "\x00=&") && !strings.ContainsRune(value, 0)
+}
+
+// check the metadata outputs of a transaction: each decodes and together they pass validateMetadata
+func (tx *Transaction) checkMetadata() error {
+	for i, out := range tx.Outputs {
+		kind, payload := out.payload()
+		if kind != DataMetadata {
+			continue
+		}
+
+		var entries []MetadataEntry
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&entries); err != nil {
+			return fmt.Errorf("metadata output %d does not decode", i)
+		}
+	}
+
+	return validateMetadata(tx.Metadata())
+}
+
+// create a data output holding the metadata, entries are sorted so the output does not depend on map order
+func NewMetadataOutput(metadata map[string]string) (*TransactionOutput, error) {
+	if err := validateMetadata(metadata); err != nil {
+		return nil, err
+	}
+
+	var entries []MetadataEntry
+	for key, value := range metadata {
+		entries = append(entries, MetadataEntry{key, value})
+	}
+	slices.SortFunc(entries, func(a, b MetadataEntry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return NewDataOutput(0, DataMetadata, encodeGob(entries)), nil
+}
+
+// create a new transaction that also carries metadata
+func NewTransactionWithMetadata(w *wallet.Wallet, to string, amount, fee int, metadata map[string]string, UTXO *UTXOSet) (*Transaction, error) {
+	metadataOutput, err := NewMetadataOutput(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := []TransactionOutput{*NewTransactionOutput(amount, to), *metadataOutput}
+
+	return newSpendingTransaction(w, amount, fee, outputs, UTXO), nil
+}
+
+// collect the metadata of every metadata output of the transaction
+func (tx *Transaction) Metadata() map[string]string {
+	metadata := make(map[string]string)
+
+	for _, out := range tx.Outputs {
+		kind, payload := out.payload()
+		if kind != DataMetadata {
+			continue
+		}
+
+		var entries []MetadataEntry
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&entries); err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			metadata[entry.Key] = entry.Value
+		}
+	}
+
+	return metadata
+}
+
+func metadataKey(key, value string, txID []byte) []byte {
+	k := append(slicesCopy(MetadataPrefix), key...)
+	k = append(k, 0)
+	k = append(k, value...)
+	k = append(k, 0)
+	return append(k, txID...)
+}
+
+// index the metadata of a connected transaction
+// entries breaking the separators are left out, blocks connected before they were rejected may still hold some
+func indexMetadata(txn *badger.Txn, tx *Transaction, height int) error {
+	for key, value := range tx.Metadata() {
+		if !indexableEntry(key, value) {
+			continue
+		}
+		if err := txn.Set(metadataKey(key, value, tx.ID), binary.BigEndian.AppendUint64(nil, uint64(height))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// drop the metadata of a disconnected transaction from the index
+func unindexMetadata(txn *badger.Txn, tx *Transaction) error {
+	for key, value := range tx.Metadata() {
+		if !indexableEntry(key, value) {
+			continue
+		}
+		if err := txn.Delete(metadataKey(key, value, tx.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// a single term of a query
+type metadataTerm struct {
+	key    string
+	value  string
+	prefix bool // the value ends with "*", so it matches every value starting with it
+	any    bool // no value was given, so every value matches
+}
+
+func parseMetadataQuery(query string) ([]metadataTerm, error) {
+	var terms []metadataTerm
+
+	for _, part := range strings.Split(query, "&") {
+		key, value, hasValue := strings.Cut(part, "=")
+		if key == "" {
+			return nil, fmt.Errorf("query term %q has no key", part)
+		}
+
+		term := metadataTerm{key: key, any: !hasValue}
+		if hasValue && strings.HasSuffix(value, "*") {
+			term.value = strings.TrimSuffix(value, "*")
+			term.prefix = true
+		} else {
+			term.value = value
+		}
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+// find the transactions matching a single term, keyed by transaction ID
+func (chain *BlockChain) searchMetadataTerm(term metadataTerm) map[string]int {
+	matches := make(map[string]int)
+
+	seek := append(slicesCopy(MetadataPrefix), term.key...)
+	seek = append(seek, 0)
+	keyPrefix := len(seek)
+	if !term.any {
+		seek = append(seek, term.value...)
+		if !term.prefix {
+			seek = append(seek, 0)
+		}
+	}
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(seek); it.ValidForPrefix(seek); it.Next() {
+			item := it.Item()
+			key := item.KeyCopy(nil)
+
+			// values never contain the separator, so the first one after the key ends the value
+			rest := key[keyPrefix:]
+			separator := bytes.IndexByte(rest, 0)
+			if separator == -1 {
+				continue
+			}
+			txID := rest[separator+1:]
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			matches[string(txID)] = int(binary.BigEndian.Uint64(value))
+		}
+
+		return nil
+	})
+	Handle(err)
+
+	return matches
+}
+
+// look transactions up by their metadata
+// a query is one or more terms joined by "&": "key=value" matches exactly, "key=val*" matches a prefix
+// and a bare "key" matches any value; every term has to match
+func (chain *BlockChain) SearchTransactions(query string) ([]MetadataMatch, error) {
+	terms, err := parseMetadataQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	found := chain.searchMetadataTerm(terms[0])
+	for _, term := range terms[1:] {
+		other := chain.searchMetadataTerm(term)
+		for txID := range found {
+			if _, ok := other[txID]; !ok {
+				delete(found, txID)
+			}
+		}
+	}
+
+	var matches []MetadataMatch
+	for txID, height := range found {
+		tx, err := chain.transactionAt(height, []byte(txID))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, MetadataMatch{tx.ID, height, tx.Metadata()})
+	}
+
+	// oldest payments first, ties broken by ID so the order is stable
+	slices.SortFunc(matches, func(a, b MetadataMatch) int {
+		if a.Height != b.Height {
+			return a.Height - b.Height
+		}
+		return bytes.Compare(a.TxID, b.TxID)
+	})
+
+	return matches, nil
+}
+
+// fetch a transaction from the main chain block at the given height
+func (chain *BlockChain) transactionAt(height int, txID []byte) (*Transaction, error) {
+	hash, err := chain.GetBlockHashAt(height)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := chain.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			return tx, nil
+		}
+	}
+
+	return nil, fmt.Errorf("transaction %x is not in block %x", txID, hash)
+}
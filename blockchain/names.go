@@ -0,0 +1,239 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"regexp"
+
+	"github.com/dgraph-io/badger"
+)
+
+// human-readable names bound to a public key, so coins can be sent to "alice" instead of a raw address
+// a name belongs to the key signing the first input of the transaction registering it and lasts NameExpiry
+// blocks, its owner renews it before someone else may register it again
+// operations that do not hold when their block is connected, such as registering a name that is taken or not
+// paying NameFee, are ignored instead of invalidating the block, so the first valid claim of a name always wins
+const (
+	NameRegister = "register"
+	NameRenew    = "renew"
+
+	NameExpiry = 1000 // the number of blocks a registration or renewal lasts
+	NameFee    = 1    // left to the miner by every name operation, so names cannot be hoarded for free
+)
+
+// name index: name -> the current record
+var NamePrefix = []byte("name-")
+
+var (
+	namePattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,30}[a-z0-9]$`)
+	errNameNotFound = errors.New("name is not registered")
+)
+
+// the payload of a name output
+type NameOperation struct {
+	Op   string // NameRegister or NameRenew
+	Name string
+}
+
+type NameRecord struct {
+	Name       string
+	Owner      []byte // the public key hash of the owner
+	PublicKey  []byte // the owner's public key
+	TxID       []byte // the transaction that last registered or renewed the name
+	Registered int    // the height the name was registered at
+	Expires    int    // the last height the name is valid at
+}
+
+// the state of a name before a block changed it, nil if the name was free
+type nameUndo struct {
+	Name     string
+	Previous *NameRecord
+}
+
+func ValidateName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// the owner's address on the network the record was read from
+func (r NameRecord) Address(params Params) string {
+	return params.EncodeAddress(r.Owner)
+}
+
+func (r NameRecord) expired(height int) bool {
+	return height > r.Expires
+}
+
+func nameKey(name string) []byte {
+	return append(slicesCopy(NamePrefix), name...)
+}
+
+func getNameRecord(txn *badger.Txn, name string) (*NameRecord, error) {
+	data, err := getValue(txn, nameKey(name))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var record NameRecord
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// read the name operation a transaction carries, if it carries one
+func (tx *Transaction) nameOperation() (NameOperation, bool) {
+	if len(tx.Inputs) == 0 || tx.isCoinbase() {
+		return NameOperation{}, false
+	}
+
+	for _, out := range tx.Outputs {
+		kind, payload := out.payload()
+		if kind != DataName {
+			continue
+		}
+
+		var op NameOperation
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&op); err != nil {
+			return NameOperation{}, false
+		}
+		return op, ValidateName(op.Name)
+	}
+
+	return NameOperation{}, false
+}
+
+// the record a name operation leads to, or nil if the operation does not hold on top of the current record
+func applyNameOperation(op NameOperation, current *NameRecord, tx *Transaction, height int) *NameRecord {
+	publicKey := tx.Inputs[0].PublicKey
+	owner := wallet.PublicKeyHash(publicKey)
+
+	switch op.Op {
+	case NameRegister:
+		if current != nil && !current.expired(height) {
+			return nil
+		}
+		return &NameRecord{op.Name, owner, publicKey, tx.ID, height, height + NameExpiry}
+	case NameRenew:
+		if current == nil || !bytes.Equal(current.Owner, owner) {
+			return nil
+		}
+		record := *current
+		record.TxID = tx.ID
+		record.Expires = max(current.Expires, height) + NameExpiry
+		return &record
+	}
+
+	return nil
+}
+
+// update the name index for a connected transaction, remembering the previous record for disconnecting
+// spent holds the outputs the transaction's inputs spent, which the fee is worked out from
+func indexName(txn *badger.Txn, tx *Transaction, height int, spent []spentOutput, undo *blockUndo) error {
+	op, ok := tx.nameOperation()
+	if !ok {
+		return nil
+	}
+
+	if spentFee(tx, spent) < NameFee {
+		return nil
+	}
+
+	current, err := getNameRecord(txn, op.Name)
+	if err != nil {
+		return err
+	}
+
+	record := applyNameOperation(op, current, tx, height)
+	if record == nil {
+		return nil
+	}
+
+	undo.Names = append(undo.Names, nameUndo{op.Name, current})
+
+	return txn.Set(nameKey(op.Name), encodeGob(record))
+}
+
+// put back the records a disconnected block changed, the latest change first
+func unindexNames(txn *badger.Txn, names []nameUndo) error {
+	for i := len(names) - 1; i >= 0; i-- {
+		var err error
+		if names[i].Previous == nil {
+			err = txn.Delete(nameKey(names[i].Name))
+		} else {
+			err = txn.Set(nameKey(names[i].Name), encodeGob(names[i].Previous))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// create a transaction registering or renewing a name for the wallet
+// the operation is checked against the current tip, a conflicting one mined first still wins
+func (chain *BlockChain) NewNameTransaction(w *wallet.Wallet, op, name string, UTXO *UTXOSet) (*Transaction, error) {
+	if !ValidateName(name) {
+		return nil, fmt.Errorf("%q is not a valid name, names are 3 to 32 lowercase letters, digits and dashes", name)
+	}
+	if op != NameRegister && op != NameRenew {
+		return nil, fmt.Errorf("unknown name operation %q", op)
+	}
+
+	record, err := chain.LookupName(name)
+	if err != nil && err != errNameNotFound {
+		return nil, err
+	}
+
+	height := chain.GetStats().Height + 1
+	owner := wallet.PublicKeyHash(w.PublicKey)
+
+	switch {
+	case op == NameRegister && record != nil && !record.expired(height):
+		return nil, fmt.Errorf("name %s is registered until block %d", name, record.Expires)
+	case op == NameRenew && (record == nil || !bytes.Equal(record.Owner, owner)):
+		return nil, fmt.Errorf("name %s is not owned by %s", name, w.Address())
+	}
+
+	outputs := []TransactionOutput{*NewDataOutput(0, DataName, encodeGob(NameOperation{op, name}))}
+
+	// the spent outputs all belong to the wallet, so whichever input comes first binds the name to its key
+	return newSpendingTransaction(w, 0, NameFee, outputs, UTXO), nil
+}
+
+// fetch the record of a name, expired or not
+func (chain *BlockChain) LookupName(name string) (*NameRecord, error) {
+	var record *NameRecord
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		record, err = getNameRecord(txn, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, errNameNotFound
+	}
+
+	return record, nil
+}
+
+// turn a registered name into its owner's address, expired names do not resolve
+func (chain *BlockChain) ResolveName(name string) (string, error) {
+	record, err := chain.LookupName(name)
+	if err != nil {
+		return "", err
+	}
+
+	if record.expired(chain.GetStats().Height) {
+		return "", fmt.Errorf("name %s expired at block %d", name, record.Expires)
+	}
+
+	return record.Address(chain.Params), nil
+}
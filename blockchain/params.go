@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"os"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// the parameters defining a network, so another network is a matter of data instead of a fork of the code
+// a chain stores its parameters when it is created and keeps them from then on, changing the defaults below only
+// affects chains created afterwards
+type Params struct {
+	ChainID          string `json:"chainId"`
+	GenesisData      string `json:"genesisData"`      // the data of the genesis block's coinbase
+	BlockReward      int    `json:"blockReward"`      // the coins created by a block before any halving
+	HalvingInterval  int    `json:"halvingInterval"`  // the number of blocks after which the reward halves, 0 keeps it constant
+	CoinbaseMaturity int    `json:"coinbaseMaturity"` // the confirmations a coinbase output needs before wallets spend it
+	Difficulty       int    `json:"difficulty"`       // the number of leading zero bits a block hash needs
+	AddressVersion   byte   `json:"addressVersion"`   // the version byte of the network's addresses
+	MaxBlockSize     int    `json:"maxBlockSize"`     // the largest total virtual size of a block's transactions, until governance changes it
+	MinFee           int    `json:"minFee"`           // the smallest fee a transaction pays to be mined, until governance changes it
+}
+
+var MainParams = Params{
+	ChainID:          MainChainID,
+	GenesisData:      "First Transaction from genesis",
+	BlockReward:      20,
+	HalvingInterval:  0,
+	CoinbaseMaturity: 0,
+	Difficulty:       20,
+	AddressVersion:   0x00,
+	MaxBlockSize:     1 << 20,
+	MinFee:           0,
+}
+
+var paramsKey = []byte("params")
+
+var (
+	networksMu sync.RWMutex
+	networks   = map[string]Params{MainChainID: MainParams}
+)
+
+// make a network's parameters known, so its chains can be created and their proofs checked by ID
+func RegisterParams(p Params) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	networksMu.Lock()
+	defer networksMu.Unlock()
+
+	networks[p.ChainID] = p
+	return nil
+}
+
+// the parameters of a registered network, chains nobody registered, such as sidechains, share the main
+// chain's parameters under their own ID
+func ParamsFor(chainID string) Params {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+
+	if p, ok := networks[chainID]; ok {
+		return p
+	}
+
+	p := MainParams
+	p.ChainID = chainID
+	return p
+}
+
+// read a network's parameters from a JSON file, fields left out keep the main chain's values
+func LoadParams(file string) (Params, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Params{}, err
+	}
+
+	p := MainParams
+	p.ChainID = ""
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Params{}, err
+	}
+
+	return p, p.validate()
+}
+
+func (p Params) validate() error {
+	if err := ValidateChainID(p.ChainID); err != nil {
+		return err
+	}
+
+	switch {
+	case p.BlockReward < 0 || p.HalvingInterval < 0 || p.CoinbaseMaturity < 0 || p.MinFee < 0:
+		return errors.New("rewards, intervals and fees cannot be negative")
+	case p.Difficulty < 1 || p.Difficulty > 255:
+		return fmt.Errorf("difficulty %d is out of range", p.Difficulty)
+	case p.MaxBlockSize < 1:
+		return errors.New("blocks must have room for transactions")
+	}
+
+	return nil
+}
+
+// the coins created by the block at the given height
+func (p Params) Reward(height int) int {
+	if p.HalvingInterval == 0 {
+		return p.BlockReward
+	}
+
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.BlockReward >> halvings
+}
+
+// the address of a public key hash on this network
+func (p Params) EncodeAddress(publicKeyHash []byte) string {
+	return string(wallet.EncodeAddressVersion(publicKeyHash, p.AddressVersion))
+}
+
+// read the parameters a database was created with
+// databases created before parameters were stored use the parameters registered for their chain
+func readParams(db *badger.DB, chainID string) (Params, error) {
+	p := ParamsFor(chainID)
+
+	err := db.View(func(txn *badger.Txn) error {
+		return decodeStoredParams(txn, &p)
+	})
+
+	return p, err
+}
+
+// the parameters of the chain a database belongs to, read inside a transaction
+func getParams(txn *badger.Txn) (Params, error) {
+	chainID, err := getValue(txn, chainIDKey)
+	if err != nil {
+		return Params{}, err
+	}
+
+	p := ParamsFor(MainChainID)
+	if chainID != nil {
+		p = ParamsFor(string(chainID))
+	}
+
+	return p, decodeStoredParams(txn, &p)
+}
+
+func decodeStoredParams(txn *badger.Txn, p *Params) error {
+	data, err := getValue(txn, paramsKey)
+	if err != nil || data == nil {
+		return err
+	}
+	return json.Unmarshal(data, p)
+}
+
+func encodeParams(p Params) []byte {
+	data, err := json.Marshal(p)
+	Handle(err)
+
+	return data
+}
@@ -16,32 +16,38 @@ import (
 // requirements:
 // first few bytes of the has must contain 0s
 
-const Difficulty = 20
+// the difficulty is a chain parameter, see Params
 
 type ProofOfWork struct {
-	Block  *Block
-	Target *big.Int
+	Block      *Block
+	Target     *big.Int
+	Difficulty int
 }
 
-func NewProof(b *Block) *ProofOfWork {
+func NewProof(b *Block, difficulty int) *ProofOfWork {
 	target := big.NewInt(1)
 
-	// left shift the bytes 256-Difficulty times
+	// left shift the bytes 256-difficulty times
 	// 256 is used because it represents the size of the block's hash
 	// the number 00000000....000001 would now be 0000...0001000...000000
 	//            ^256th bit       ^1st bit                 ^"difficulty"th bit
-	target.Lsh(target, uint(256-Difficulty))
+	target.Lsh(target, uint(256-difficulty))
 
-	return &ProofOfWork{b, target}
+	return &ProofOfWork{b, target, difficulty}
 }
 
 func (proof *ProofOfWork) InitData(nonce int) []byte {
+	return powData(proof.Block.PrevHash, proof.Block.HashTransactions(), nonce, proof.Difficulty)
+}
+
+// the bytes hashed by the proof-of-work, shared by full blocks and bare headers
+func powData(prevHash, merkleRoot []byte, nonce, difficulty int) []byte {
 	data := bytes.Join(
 		[][]byte{
-			proof.Block.PrevHash,
-			proof.Block.HashTransactions(),
+			prevHash,
+			merkleRoot,
 			toHex(int64(nonce)),
-			toHex(int64(Difficulty)),
+			toHex(int64(difficulty)),
 		},
 		[]byte{},
 	)
@@ -90,3 +96,8 @@ func (pow *ProofOfWork) Validate() bool {
 
 	return intHash.Cmp(pow.Target) == -1
 }
+
+// check that a header's hash is the one its fields produce and that it meets the target of the given difficulty
+func (h *BlockHeader) Validate(difficulty int) bool {
+	return CheckHeader(h, difficulty) == nil
+}
@@ -0,0 +1,259 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	hashLength = 32 // a block hash is a sha256 digest, every other key in the database is either prefixed or "lh"
+
+	// a file kept in the database directory while the database is open, finding it when opening the database
+	// means the last process using it did not close it cleanly
+	openMarker = "OPEN"
+)
+
+// check every block down to the genesis block when a chain is opened, not only after an unclean shutdown
+var FullCheckOnOpen bool
+
+// mark the database at path as open, reporting whether it was left open by a process that did not close it
+func markOpen(path string) (bool, error) {
+	marker := filepath.Join(path, openMarker)
+
+	_, err := os.Stat(marker)
+	dirty := err == nil
+
+	return dirty, os.WriteFile(marker, nil, 0644)
+}
+
+// close the chain database, marking it as cleanly closed
+func (chain *BlockChain) Close() error {
+	if err := chain.Database.Close(); err != nil {
+		return err
+	}
+	if chain.path == "" {
+		return nil
+	}
+
+	return os.Remove(filepath.Join(chain.path, openMarker))
+}
+
+// decode a block without panicking, so that damaged entries can be told apart from valid ones
+func deserializeBlock(data []byte) (*Block, error) {
+	var block Block
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&block); err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
+// a block is intact if it decodes, its stored hash is the one its proof-of-work produces and it is well formed
+func isIntact(hash, data []byte, difficulty int) (*Block, bool) {
+	block, err := deserializeBlock(data)
+	if err != nil || !bytes.Equal(block.Hash, hash) {
+		return nil, false
+	}
+
+	if CheckBlock(block, difficulty) != nil {
+		return nil, false
+	}
+
+	return block, true
+}
+
+// read the last hash pointer, failing instead of panicking when it is missing
+func readLastHash(db *badger.DB) ([]byte, error) {
+	var lastHash []byte
+
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("lh"))
+		if err != nil {
+			return err
+		}
+
+		lastHash, err = item.ValueCopy(nil)
+		return err
+	})
+
+	return lastHash, err
+}
+
+// walk from the given hash down to the genesis block, checking that every block on the way is intact
+func checkChainFrom(db *badger.DB, hash []byte, difficulty int) error {
+	return db.View(func(txn *badger.Txn) error {
+		current := hash
+		height := -1
+
+		for {
+			item, err := txn.Get(current)
+			if err != nil {
+				return fmt.Errorf("missing block %x", current)
+			}
+
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			block, ok := isIntact(current, data, difficulty)
+			if !ok {
+				return fmt.Errorf("invalid block %x", current)
+			}
+
+			// heights must decrease by exactly one on every step towards the genesis block
+			if height != -1 && block.Height != height-1 {
+				return errors.New("broken block height sequence")
+			}
+			height = block.Height
+
+			if len(block.PrevHash) == 0 {
+				if block.Height != 0 {
+					return errors.New("genesis block with non-zero height")
+				}
+				return nil
+			}
+
+			current = block.PrevHash
+		}
+	})
+}
+
+// find the highest block whose whole ancestry down to the genesis block is intact
+func findLastValidBlock(db *badger.DB, difficulty int) ([]byte, int, error) {
+	blocks := make(map[string]*Block)
+
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.KeyCopy(nil)
+			if len(key) != hashLength {
+				continue
+			}
+
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				// an unreadable value is treated like a missing block
+				continue
+			}
+
+			if block, ok := isIntact(key, data, difficulty); ok {
+				blocks[string(key)] = block
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// memoize which blocks reach the genesis block so each branch is only walked once
+	reachesGenesis := make(map[string]bool)
+	var connected func(block *Block) bool
+	connected = func(block *Block) bool {
+		key := string(block.Hash)
+		if ok, seen := reachesGenesis[key]; seen {
+			return ok
+		}
+
+		ok := false
+		if len(block.PrevHash) == 0 {
+			ok = block.Height == 0
+		} else if parent, found := blocks[string(block.PrevHash)]; found && parent.Height == block.Height-1 {
+			ok = connected(parent)
+		}
+
+		reachesGenesis[key] = ok
+		return ok
+	}
+
+	var best *Block
+	for _, block := range blocks {
+		if !connected(block) {
+			continue
+		}
+		if best == nil || block.Height > best.Height {
+			best = block
+		}
+	}
+
+	if best == nil {
+		return nil, 0, errors.New("no intact chain left in the database")
+	}
+
+	return best.Hash, best.Height, nil
+}
+
+// check that the tip block is intact and rests on a stored parent
+func checkTip(db *badger.DB, hash []byte, difficulty int) error {
+	return db.View(func(txn *badger.Txn) error {
+		data, err := getValue(txn, hash)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return fmt.Errorf("missing block %x", hash)
+		}
+
+		block, ok := isIntact(hash, data, difficulty)
+		if !ok {
+			return fmt.Errorf("invalid block %x", hash)
+		}
+		if len(block.PrevHash) == 0 {
+			return nil
+		}
+
+		parent, err := getValue(txn, block.PrevHash)
+		if err == nil && parent == nil {
+			err = fmt.Errorf("missing block %x", block.PrevHash)
+		}
+		return err
+	})
+}
+
+// check the chain's tip on startup and, if a torn write left it dangling, roll back to the last valid block
+// the whole chain is checked when asked to, after an unclean shutdown for instance, the tip alone otherwise
+func (chain *BlockChain) recoverTip(full bool) {
+	check := checkTip
+	if full {
+		check = checkChainFrom
+	}
+
+	lastHash, err := readLastHash(chain.Database)
+	if err == nil {
+		if err = check(chain.Database, lastHash, chain.Params.Difficulty); err == nil {
+			chain.LastHash = lastHash
+			return
+		}
+	}
+
+	log.Printf("chain tip is damaged (%s), looking for the last valid block", err)
+
+	validHash, height, err := findLastValidBlock(chain.Database, chain.Params.Difficulty)
+	Handle(err)
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("lh"), validHash)
+	})
+	Handle(err)
+
+	chain.LastHash = validHash
+	log.Printf("rolled the chain tip back to block %x at height %d", validHash, height)
+
+	// the UTXO set may reference blocks past the new tip, so it is rebuilt from the recovered chain
+	UTXOSet := UTXOSet{Blockchain: chain}
+	UTXOSet.Reindex()
+	log.Printf("rebuilt the UTXO set, it now holds %d transactions", UTXOSet.CountTransactions())
+}
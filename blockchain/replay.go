@@ -0,0 +1,360 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"os"
+	"slices"
+
+	"github.com/dgraph-io/badger"
+)
+
+// a fixture records a sequence of blocks and the state the chain must end up in after executing them
+// replaying it on a scratch database turns any change to the consensus rules into a visible state difference
+type Fixture struct {
+	Chain   string        `json:"chain"`
+	Params  *Params       `json:"params,omitempty"` // the parameters of the chain, the ones registered for it when left out
+	Genesis string        `json:"genesis"`          // the serialized genesis block, hex encoded
+	Steps   []FixtureStep `json:"steps"`
+	Expect  FixtureState  `json:"expect"`
+}
+
+// a single step extends the chain by one block, either recorded as is or assembled from transactions
+// assembled blocks are deterministic: the pool is ranked the same way every time, the coinbase data and
+// the timestamp are fixed, so the proof-of-work always finds the same block
+type FixtureStep struct {
+	Block        string   `json:"block,omitempty"`        // a serialized block, hex encoded
+	Transactions []string `json:"transactions,omitempty"` // serialized transactions, hex encoded
+	Miner        string   `json:"miner,omitempty"`
+	CoinbaseData string   `json:"coinbaseData,omitempty"`
+	Timestamp    int64    `json:"timestamp,omitempty"`
+	Hash         string   `json:"hash,omitempty"` // the hash the block must have, optional
+}
+
+// the state asserted at the end of a replay, empty fields are not checked
+type FixtureState struct {
+	TipHash        string         `json:"tipHash,omitempty"`
+	Height         int            `json:"height"`
+	UTXOCommitment string         `json:"utxoCommitment,omitempty"`
+	Balances       map[string]int `json:"balances,omitempty"`
+}
+
+type ReplayResult struct {
+	State      FixtureState // what the replay ended up with
+	Mismatches []string     // every expectation the state did not meet
+}
+
+func (r *ReplayResult) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+func LoadFixture(file string) (*Fixture, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, err
+	}
+
+	return &fixture, nil
+}
+
+func (f *Fixture) Save(file string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, data, 0644)
+}
+
+// record the main chain from its genesis block up, expecting the state the chain is in right now
+// the balances of the given addresses are recorded too
+func (chain *BlockChain) RecordFixture(addresses []string) (*Fixture, error) {
+	stats := chain.GetStats()
+
+	var blocks []*Block
+	for height := 0; height <= stats.Height; height++ {
+		hash, err := chain.GetBlockHashAt(height)
+		if err != nil {
+			return nil, err
+		}
+
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, &block)
+	}
+
+	params := chain.Params
+	fixture := Fixture{Chain: chain.ChainID, Params: &params, Genesis: hex.EncodeToString(blocks[0].Serialize())}
+	for _, block := range blocks[1:] {
+		fixture.Steps = append(fixture.Steps, FixtureStep{Block: hex.EncodeToString(block.Serialize())})
+	}
+
+	state, err := chain.fixtureState(addresses)
+	if err != nil {
+		return nil, err
+	}
+	fixture.Expect = state
+
+	return &fixture, nil
+}
+
+// execute the fixture on a scratch database and compare the resulting state with the expected one
+// an error means a step could not be executed at all, a block breaking the rules is one of them
+func Replay(f *Fixture) (*ReplayResult, error) {
+	dir, err := os.MkdirTemp("", "replay-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	chainID := f.Chain
+	if chainID == "" {
+		chainID = MainChainID
+	}
+
+	params := ParamsFor(chainID)
+	if f.Params != nil {
+		params = *f.Params
+		params.ChainID = chainID
+		if err := params.validate(); err != nil {
+			return nil, fmt.Errorf("params: %w", err)
+		}
+	}
+
+	genesisBlock, err := decodeFixtureBlock(f.Genesis, params.Difficulty)
+	if err != nil {
+		return nil, fmt.Errorf("genesis: %w", err)
+	}
+
+	// the scratch directory exists already, so it cannot be given to the database as is
+	chain, err := newBlockChainFrom(genesisBlock, params, dir+"/chain")
+	if err != nil {
+		return nil, err
+	}
+	defer chain.Close()
+
+	for i, step := range f.Steps {
+		block, err := chain.replayStep(step)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+
+		if step.Hash != "" && step.Hash != hex.EncodeToString(block.Hash) {
+			return nil, fmt.Errorf("step %d: block hash is %x, %s was expected", i+1, block.Hash, step.Hash)
+		}
+	}
+
+	var addresses []string
+	for address := range f.Expect.Balances {
+		addresses = append(addresses, address)
+	}
+
+	state, err := chain.fixtureState(addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplayResult{state, compareFixtureStates(f.Expect, state)}, nil
+}
+
+func decodeFixtureBlock(data string, difficulty int) (*Block, error) {
+	raw, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := deserializeBlock(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// the hash and the proof-of-work have to hold, a recorded block is never trusted blindly
+	if _, ok := isIntact(block.Hash, raw, difficulty); !ok {
+		return nil, fmt.Errorf("block %x is invalid", block.Hash)
+	}
+
+	return block, nil
+}
+
+// extend the chain by the step's block
+func (chain *BlockChain) replayStep(step FixtureStep) (*Block, error) {
+	stats := chain.GetStats()
+
+	var block *Block
+	var err error
+
+	switch {
+	case step.Block != "" && len(step.Transactions) > 0:
+		return nil, errors.New("a step holds either a block or transactions")
+	case step.Block != "":
+		block, err = decodeFixtureBlock(step.Block, chain.Params.Difficulty)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		block, err = chain.assembleFixtureBlock(step, stats.Height+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if block.Height != stats.Height+1 {
+		return nil, fmt.Errorf("block %x is at height %d, the tip is at %d", block.Hash, block.Height, stats.Height)
+	}
+
+	// transactions may spend the outputs of the transactions before them in the same block
+	pool := make(map[string]Transaction)
+	for _, tx := range block.Transactions {
+		if !chain.VerifyPoolTransaction(tx, pool) {
+			return nil, fmt.Errorf("transaction %x is invalid", tx.ID)
+		}
+		pool[hex.EncodeToString(tx.ID)] = *tx
+	}
+
+	if err := chain.ConnectBlock(block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// mine the step's transactions the way a node would, with a fixed coinbase and timestamp
+func (chain *BlockChain) assembleFixtureBlock(step FixtureStep, height int) (*Block, error) {
+	if !wallet.ValidateAddress(step.Miner) {
+		return nil, errors.New("an assembled block needs a valid miner address")
+	}
+	if step.CoinbaseData == "" {
+		return nil, errors.New("an assembled block needs fixed coinbase data")
+	}
+
+	pool := make(map[string]Transaction)
+	for _, data := range step.Transactions {
+		raw, err := hex.DecodeString(data)
+		if err != nil {
+			return nil, err
+		}
+
+		tx := DeserializeTransaction(raw)
+		pool[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	txs := chain.SelectTransactions(pool, chain.Parameter(ParamMaxBlockSize, chain.Params.MaxBlockSize), chain.Parameter(ParamMinFee, chain.Params.MinFee))
+	if len(txs) != len(pool) {
+		return nil, fmt.Errorf("only %d of the step's %d transactions can be mined", len(txs), len(pool))
+	}
+
+	fees := 0
+	for _, tx := range txs {
+		fee, err := chain.TransactionFee(tx, pool)
+		if err != nil {
+			return nil, err
+		}
+		fees += fee
+	}
+	txs = append(txs, newCoinbaseTx(step.Miner, step.CoinbaseData, chain.Params.Reward(height)+fees))
+
+	return createBlockAt(txs, chain.LastHash, height, chain.Params.Difficulty, step.Timestamp), nil
+}
+
+func (chain *BlockChain) fixtureState(addresses []string) (FixtureState, error) {
+	stats := chain.GetStats()
+
+	commitment, err := chain.UTXOCommitment()
+	if err != nil {
+		return FixtureState{}, err
+	}
+
+	state := FixtureState{
+		TipHash:        hex.EncodeToString(stats.TipHash),
+		Height:         stats.Height,
+		UTXOCommitment: hex.EncodeToString(commitment),
+		Balances:       make(map[string]int),
+	}
+
+	for _, address := range addresses {
+		if !wallet.ValidateAddress(address) {
+			return FixtureState{}, fmt.Errorf("address %s is invalid", address)
+		}
+
+		publicKeyHash := wallet.Base58Decode([]byte(address))
+		publicKeyHash = publicKeyHash[1 : len(publicKeyHash)-4]
+		state.Balances[address] = chain.GetBalance(publicKeyHash)
+	}
+
+	return state, nil
+}
+
+func compareFixtureStates(expect, got FixtureState) []string {
+	var mismatches []string
+
+	if expect.TipHash != "" && expect.TipHash != got.TipHash {
+		mismatches = append(mismatches, fmt.Sprintf("tip hash is %s, %s was expected", got.TipHash, expect.TipHash))
+	}
+	if expect.Height != got.Height {
+		mismatches = append(mismatches, fmt.Sprintf("height is %d, %d was expected", got.Height, expect.Height))
+	}
+	if expect.UTXOCommitment != "" && expect.UTXOCommitment != got.UTXOCommitment {
+		mismatches = append(mismatches, fmt.Sprintf("UTXO commitment is %s, %s was expected", got.UTXOCommitment, expect.UTXOCommitment))
+	}
+
+	var addresses []string
+	for address := range expect.Balances {
+		addresses = append(addresses, address)
+	}
+	slices.Sort(addresses)
+
+	for _, address := range addresses {
+		if expect.Balances[address] != got.Balances[address] {
+			mismatches = append(mismatches, fmt.Sprintf("balance of %s is %d, %d was expected", address, got.Balances[address], expect.Balances[address]))
+		}
+	}
+
+	return mismatches
+}
+
+// hash the whole UTXO set into a single value
+// every unspent output is written as transaction ID, index, value and public key hash in key order,
+// so two chains share a commitment exactly when they agree on what can be spent
+func (chain *BlockChain) UTXOCommitment() ([]byte, error) {
+	hasher := sha256.New()
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(UTXOPrefix); it.ValidForPrefix(UTXOPrefix); it.Next() {
+			item := it.Item()
+			txID := bytes.TrimPrefix(item.KeyCopy(nil), UTXOPrefix)
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			outs := DeserializeOutputs(value)
+
+			for i, out := range outs.Outputs {
+				hasher.Write(txID)
+				hasher.Write(binary.BigEndian.AppendUint64(nil, uint64(outs.index(i))))
+				hasher.Write(binary.BigEndian.AppendUint64(nil, uint64(out.Value)))
+				hasher.Write(out.PublicKeyHash)
+			}
+		}
+
+		return nil
+	})
+
+	return hasher.Sum(nil), err
+}
@@ -0,0 +1,241 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"math/big"
+)
+
+// a signature hash type selects which parts of the transaction a signature commits to
+// signatures that commit to less can be combined with inputs and outputs added by other parties later on
+type SigHashType byte
+
+const (
+	SigHashAll    SigHashType = 0x01 // every input and every output, nothing can change after signing
+	SigHashNone   SigHashType = 0x02 // every input but no output, anyone may decide where the coins go
+	SigHashSingle SigHashType = 0x03 // every input and the output with the same index as the signed input
+
+	// combined with one of the above, only the signed input is committed to so others can add their own
+	// ALL|ANYONECANPAY lets contributors fund a fixed set of outputs, as a crowdfunding campaign does
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+// signatures are r and s padded to 32 bytes followed by the hash type
+// signatures made before hash types existed are shorter, they commit to everything as SigHashAll does
+const (
+	sigComponentLength = 32
+	signatureLength    = 2*sigComponentLength + 1
+)
+
+func (t SigHashType) base() SigHashType {
+	return t &^ SigHashAnyoneCanPay
+}
+
+func (t SigHashType) valid() bool {
+	base := t.base()
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+func (t SigHashType) String() string {
+	var name string
+
+	switch t.base() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("UNKNOWN(%#x)", byte(t))
+	}
+
+	if t&SigHashAnyoneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+
+	return name
+}
+
+// the digest signed for an input, the hash type decides which inputs and outputs are kept
+// legacy digests come from the original scheme and do not commit to the hash type
+func (tx *Transaction) sigHash(inId int, previousTXs map[string]Transaction, hashType SigHashType, legacy bool) ([]byte, error) {
+	if !hashType.valid() {
+		return nil, fmt.Errorf("unknown signature hash type %#x", byte(hashType))
+	}
+
+	txCopy := tx.trimmedCopy()
+
+	// recreate the state of the transaction at signing time: only the signed input carries the previous output's key
+	in := txCopy.Inputs[inId]
+	previousTX := previousTXs[hex.EncodeToString(in.ID)]
+	txCopy.Inputs[inId].PublicKey = previousTX.Outputs[in.Output].PublicKeyHash
+
+	switch hashType.base() {
+	case SigHashNone:
+		txCopy.Outputs = nil
+	case SigHashSingle:
+		if inId >= len(txCopy.Outputs) {
+			return nil, fmt.Errorf("input %d has no matching output to sign", inId)
+		}
+		txCopy.Outputs = []TransactionOutput{txCopy.Outputs[inId]}
+	}
+
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txCopy.Inputs = []TransactionInput{txCopy.Inputs[inId]}
+	}
+
+	if legacy {
+		return txCopy.hash(), nil
+	}
+
+	txCopy.ID = []byte{}
+	hash := sha256.Sum256(append(txCopy.Serialize(), byte(hashType)))
+
+	return hash[:], nil
+}
+
+// sign a single input with the given hash type
+func (tx *Transaction) signInput(inId int, privateKey ecdsa.PrivateKey, previousTXs map[string]Transaction, hashType SigHashType) error {
+	digest, err := tx.sigHash(inId, previousTXs, hashType, false)
+	if err != nil {
+		return err
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, &privateKey, digest)
+	if err != nil {
+		return err
+	}
+
+	// pad both components so the signature can be split without knowing their length
+	signature := make([]byte, signatureLength)
+	r.FillBytes(signature[:sigComponentLength])
+	s.FillBytes(signature[sigComponentLength : 2*sigComponentLength])
+	signature[signatureLength-1] = byte(hashType)
+
+	tx.Inputs[inId].Signature = signature
+
+	return nil
+}
+
+// split a signature into its components and hash type, telling whether it uses the legacy encoding
+func decodeSignature(signature []byte) (r, s *big.Int, hashType SigHashType, legacy bool) {
+	r, s = new(big.Int), new(big.Int)
+
+	if len(signature) == signatureLength {
+		r.SetBytes(signature[:sigComponentLength])
+		s.SetBytes(signature[sigComponentLength : 2*sigComponentLength])
+		return r, s, SigHashType(signature[signatureLength-1]), false
+	}
+
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	return r, s, SigHashAll, true
+}
+
+// the hash type every input of the transaction was signed with
+func (tx *Transaction) SigHashTypes() []SigHashType {
+	var types []SigHashType
+
+	for _, in := range tx.Inputs {
+		_, _, hashType, _ := decodeSignature(in.Signature)
+		types = append(types, hashType)
+	}
+
+	return types
+}
+
+// sign the inputs of the transaction spending outputs of the key, leaving everyone else's signatures alone
+func (chain *BlockChain) SignTransactionWith(tx *Transaction, privateKey ecdsa.PrivateKey, hashType SigHashType) error {
+	publicKeyHash := wallet.PublicKeyHash(append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...))
+
+	previousTXs, err := chain.previousTransactions(tx, nil)
+	if err != nil {
+		return err
+	}
+
+	signed := 0
+	for inId, in := range tx.Inputs {
+		previousTX := previousTXs[hex.EncodeToString(in.ID)]
+		if !previousTX.Outputs[in.Output].isLockedWithKey(publicKeyHash) {
+			continue
+		}
+
+		if err := tx.signInput(inId, privateKey, previousTXs, hashType); err != nil {
+			return err
+		}
+		signed++
+	}
+
+	if signed == 0 {
+		return errors.New("the key owns none of the transaction's inputs")
+	}
+
+	return nil
+}
+
+// add inputs worth amount from the wallet to a transaction built by someone else, and sign them with the hash type
+// the change goes into a new output, which breaks earlier signatures committing to every output,
+// so contributions to an ALL|ANYONECANPAY transaction should be made with exactly sized outputs
+func FundTransaction(w *wallet.Wallet, tx *Transaction, amount int, hashType SigHashType, UTXO *UTXOSet) error {
+	if !hashType.valid() {
+		return fmt.Errorf("unknown signature hash type %#x", byte(hashType))
+	}
+
+	publicKeyHash := wallet.PublicKeyHash(w.PublicKey)
+
+	acc, validOutputs := UTXO.FindSpendableOutputs(publicKeyHash, amount)
+	if acc < amount {
+		return errors.New("not enough funds")
+	}
+
+	for txId, outputs := range validOutputs {
+		txID, err := hex.DecodeString(txId)
+		if err != nil {
+			return err
+		}
+
+		for _, out := range outputs {
+			tx.Inputs = append(tx.Inputs, TransactionInput{txID, out, nil, w.PublicKey})
+		}
+	}
+
+	if acc > amount {
+		tx.Outputs = append(tx.Outputs, *NewTransactionOutput(acc-amount, string(w.Address())))
+	}
+
+	// the ID is set once the inputs and outputs are in place, before signing, as for every other transaction
+	tx.ID = tx.hash()
+
+	return UTXO.Blockchain.SignTransactionWith(tx, w.PrivateKey, hashType)
+}
+
+// locate every previous transaction that is referenced by the inputs
+// transactions still waiting in the pool are looked up there first, so unconfirmed outputs can be spent
+func (chain *BlockChain) previousTransactions(tx *Transaction, pool map[string]Transaction) (map[string]Transaction, error) {
+	previousTXs := make(map[string]Transaction)
+
+	for _, in := range tx.Inputs {
+		previousTX, ok := pool[hex.EncodeToString(in.ID)]
+		if !ok {
+			var err error
+			previousTX, err = chain.FindTransaction(in.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if in.Output < 0 || in.Output >= len(previousTX.Outputs) {
+			return nil, fmt.Errorf("transaction %x has no output %d", in.ID, in.Output)
+		}
+		previousTXs[hex.EncodeToString(previousTX.ID)] = previousTX
+	}
+
+	return previousTXs, nil
+}
@@ -0,0 +1,285 @@
+package blockchain
+
+import (
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// a simulation drives synthetic wallets against a throwaway regtest chain, the way nodes would: transactions are
+// created at a target rate, validated into a pool, and mined every block interval, so a change to the UTXO or
+// the pool code shows up as lower throughput or higher latencies instead of going unnoticed
+// the wallets only spend confirmed coins, one transaction per coin, so the load is bounded by the coins the
+// wallets hold, every transaction splits one, so the load they can carry doubles with every block
+type SimulationConfig struct {
+	Wallets       int           // the number of synthetic wallets paying each other
+	TPS           float64       // the targeted rate of transactions per second
+	BlockInterval time.Duration // the time between two blocks
+	Blocks        int           // the number of blocks to mine
+	Fee           int           // the fee every transaction pays
+	Difficulty    int           // the proof-of-work of the regtest chain, kept low so mining does not dominate
+}
+
+var DefaultSimulationConfig = SimulationConfig{
+	Wallets:       50,
+	TPS:           20,
+	BlockInterval: 5 * time.Second,
+	Blocks:        10,
+	Fee:           1,
+	Difficulty:    8,
+}
+
+// the network simulations run on, its large reward funds the wallets from the first block on
+var regtestParams = Params{
+	ChainID:      "regtest",
+	GenesisData:  "regtest genesis",
+	BlockReward:  1 << 30,
+	Difficulty:   8,
+	MaxBlockSize: 1 << 20,
+}
+
+type LatencyStats struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+type SimulationReport struct {
+	Blocks     int
+	Submitted  int // transactions created and handed to validation
+	Rejected   int // transactions failing validation, always 0 unless the rules or the wallets are broken
+	Skipped    int // transactions not created because every wallet's confirmed coins were in use
+	Confirmed  int // transactions mined
+	Elapsed    time.Duration
+	Validation LatencyStats // verifying a transaction against the chain and the pool
+	Assembly   LatencyStats // selecting a block's transactions from the pool
+	Connect    LatencyStats // connecting a mined block and updating every index
+	DBStart    int64        // the size of the database in bytes after the genesis block
+	DBEnd      int64
+}
+
+// the confirmed transactions per second over the whole simulation
+func (r SimulationReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Confirmed) / r.Elapsed.Seconds()
+}
+
+func (c SimulationConfig) validate() error {
+	switch {
+	case c.Wallets < 2:
+		return errors.New("a simulation needs at least 2 wallets")
+	case c.TPS <= 0 || c.BlockInterval <= 0 || c.Blocks < 1:
+		return errors.New("the rate, the block interval and the number of blocks must be positive")
+	case c.Fee < 0:
+		return errors.New("fees cannot be negative")
+	}
+
+	return nil
+}
+
+// an unspent output a synthetic wallet may spend
+type simulatedCoin struct {
+	owner    int
+	outpoint string
+	value    int
+}
+
+// run a simulation on a scratch database, calling progress after every block with the block and the number of
+// transactions it left in the pool
+func Simulate(c SimulationConfig, progress func(block *Block, pooled int)) (*SimulationReport, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	params := regtestParams
+	params.Difficulty = c.Difficulty
+	if err := params.validate(); err != nil {
+		return nil, fmt.Errorf("params: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "simulate-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	wallets := make([]*wallet.Wallet, c.Wallets)
+	addresses := make([]string, c.Wallets)
+	publicKeyHashes := make([][]byte, c.Wallets)
+	for i := range wallets {
+		wallets[i] = wallet.MakeWallet()
+		addresses[i] = string(wallets[i].Address())
+		publicKeyHashes[i] = wallet.PublicKeyHash(wallets[i].PublicKey)
+	}
+
+	// the miner is one of the wallets, so every block's reward joins the load
+	path := dir + "/chain"
+	chain, err := newBlockChain(addresses[0], params, path)
+	if err != nil {
+		return nil, err
+	}
+	defer chain.Close()
+
+	report := SimulationReport{Blocks: c.Blocks}
+	if report.DBStart, err = dirSize(path); err != nil {
+		return nil, err
+	}
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	coins := simulatedCoins(&UTXOSet, publicKeyHashes)
+
+	var validation, assembly, connect []time.Duration
+	start := time.Now()
+
+	for b := 0; b < c.Blocks; b++ {
+		blockStart := time.Now()
+		deadline := blockStart.Add(c.BlockInterval)
+		pool := make(map[string]Transaction)
+
+		for n := 0; ; n++ {
+			next := blockStart.Add(time.Duration(float64(n) / c.TPS * float64(time.Second)))
+			if !next.Before(deadline) {
+				break
+			}
+			time.Sleep(time.Until(next))
+
+			// the largest coin left is too small to split, so every other one is too
+			if len(coins) == 0 || coins[len(coins)-1].value-c.Fee < 2 {
+				report.Skipped++
+				continue
+			}
+			coin := coins[len(coins)-1]
+			coins = coins[:len(coins)-1]
+
+			to := rand.IntN(c.Wallets)
+			outputs := []TransactionOutput{*NewTransactionOutput((coin.value-c.Fee)/2, addresses[to])}
+			tx, err := NewTransactionFromCoins(wallets[coin.owner], []string{coin.outpoint}, c.Fee, outputs, "", &UTXOSet)
+			if err != nil {
+				return nil, err
+			}
+
+			validated := time.Now()
+			ok := chain.VerifyPoolTransaction(tx, pool)
+			validation = append(validation, time.Since(validated))
+
+			report.Submitted++
+			if !ok {
+				report.Rejected++
+				continue
+			}
+			pool[hex.EncodeToString(tx.ID)] = *tx
+		}
+		time.Sleep(time.Until(deadline))
+
+		height := chain.GetBestHeight() + 1
+
+		assembled := time.Now()
+		txs := chain.SelectTransactions(pool, chain.Parameter(ParamMaxBlockSize, chain.Params.MaxBlockSize), chain.Parameter(ParamMinFee, chain.Params.MinFee))
+		assembly = append(assembly, time.Since(assembled))
+
+		fees := 0
+		for _, tx := range txs {
+			fee, err := chain.TransactionFee(tx, pool)
+			if err != nil {
+				return nil, err
+			}
+			fees += fee
+		}
+		// the coinbase data differs from block to block, identical coinbases would share their ID
+		coinbase := newCoinbaseTx(addresses[0], fmt.Sprintf("regtest block %d", height), params.Reward(height)+fees)
+		block := createBlock(append(txs, coinbase), chain.LastHash, height, params.Difficulty)
+
+		connected := time.Now()
+		if err := chain.ConnectBlock(block); err != nil {
+			return nil, err
+		}
+		connect = append(connect, time.Since(connected))
+		report.Confirmed += len(txs)
+
+		// transactions left in the pool are dropped, the coins they spent are confirmed and spendable again
+		coins = simulatedCoins(&UTXOSet, publicKeyHashes)
+		if progress != nil {
+			progress(block, len(pool)-len(txs))
+		}
+	}
+
+	report.Elapsed = time.Since(start)
+	report.Validation = latencyStats(validation)
+	report.Assembly = latencyStats(assembly)
+	report.Connect = latencyStats(connect)
+	if report.DBEnd, err = dirSize(path); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// the confirmed coins of the wallets, smallest first, so the largest coins are split first
+// the UTXO set is scanned once for all of them
+func simulatedCoins(UTXOSet *UTXOSet, publicKeyHashes [][]byte) []simulatedCoin {
+	byKey := UTXOSet.FindCoinsOf(publicKeyHashes)
+
+	var coins []simulatedCoin
+	for owner, publicKeyHash := range publicKeyHashes {
+		for _, coin := range byKey[string(publicKeyHash)] {
+			coins = append(coins, simulatedCoin{owner, coin.Outpoint(), coin.Value})
+		}
+	}
+
+	slices.SortFunc(coins, func(a, b simulatedCoin) int { return cmp.Compare(a.value, b.value) })
+	return coins
+}
+
+func latencyStats(samples []time.Duration) LatencyStats {
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, sample := range sorted {
+		total += sample
+	}
+
+	return LatencyStats{
+		Count: len(sorted),
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   sorted[len(sorted)/2],
+		P99:   sorted[len(sorted)*99/100],
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// the bytes the files under a directory take
+func dirSize(path string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
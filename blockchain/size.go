@@ -0,0 +1,63 @@
+package blockchain
+
+// size and weight accounting, the one place block assembly, the pool and the RPC interface measure with
+// weight counts every byte WitnessScaleFactor times, except witness bytes which count once: signatures are
+// covered by the transaction ID and stored inline for now, so the weight of everything is four times its size
+// until witness data is separated from the rest of the transaction
+const WitnessScaleFactor = 4
+
+// the serialized size in bytes
+func (tx *Transaction) Size() int {
+	return len(tx.Serialize())
+}
+
+// the size without witness data
+func (tx *Transaction) BaseSize() int {
+	return tx.Size() - tx.witnessSize()
+}
+
+func (tx *Transaction) Weight() int {
+	return weight(tx.Size(), tx.witnessSize())
+}
+
+// the weight in size units, rounded up, what fee rates are measured against
+func (tx *Transaction) VirtualSize() int {
+	return virtualSize(tx.Weight())
+}
+
+// the bytes of the transaction that would move out of it with witness separation, none yet
+func (tx *Transaction) witnessSize() int {
+	return 0
+}
+
+func (b *Block) Size() int {
+	return len(b.Serialize())
+}
+
+func (b *Block) BaseSize() int {
+	return b.Size() - b.witnessSize()
+}
+
+func (b *Block) Weight() int {
+	return weight(b.Size(), b.witnessSize())
+}
+
+func (b *Block) VirtualSize() int {
+	return virtualSize(b.Weight())
+}
+
+func (b *Block) witnessSize() int {
+	size := 0
+	for _, tx := range b.Transactions {
+		size += tx.witnessSize()
+	}
+	return size
+}
+
+func weight(size, witnessSize int) int {
+	return (size-witnessSize)*WitnessScaleFactor + witnessSize
+}
+
+func virtualSize(weight int) int {
+	return (weight + WitnessScaleFactor - 1) / WitnessScaleFactor
+}
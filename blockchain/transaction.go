@@ -3,7 +3,6 @@ package blockchain
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
@@ -11,7 +10,6 @@ import (
 	"fmt"
 	"golang-blockchain/wallet"
 	"log"
-	"math/big"
 	"strings"
 )
 
@@ -35,12 +33,21 @@ func (tx *Transaction) Serialize() []byte {
 }
 
 func DeserializeTransaction(data []byte) Transaction {
+	transaction, err := deserializeTransaction(data)
+	Handle(err)
+	return *transaction
+}
+
+// decode a transaction without panicking, for input that may not be one
+func deserializeTransaction(data []byte) (*Transaction, error) {
 	var transaction Transaction
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
-	Handle(err)
-	return transaction
+	if err := decoder.Decode(&transaction); err != nil {
+		return nil, err
+	}
+
+	return &transaction, nil
 }
 
 // hash the transaction's bytes equivalent to the transaction's ID
@@ -58,9 +65,14 @@ func (tx *Transaction) hash() []byte {
 	return hash[:]
 }
 
-// create the blockchains' first transaction — the coinbase transaction
-// the coinbase includes a reward that's given to the first recepient, in this case, 100 tokens
-func CoinbaseTx(to, data string) *Transaction {
+// create a coinbase transaction for the next block of the chain, paying the chain's block reward and the fees
+// of the block's transactions
+func (chain *BlockChain) NewCoinbaseTx(to, data string, fees int) *Transaction {
+	return newCoinbaseTx(to, data, chain.Params.Reward(chain.GetBestHeight()+1)+fees)
+}
+
+// create a coinbase transaction paying the given value, the first one being the genesis block's
+func newCoinbaseTx(to, data string, value int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 24)
 		_, err := rand.Read(randData)
@@ -69,7 +81,7 @@ func CoinbaseTx(to, data string) *Transaction {
 	}
 
 	txInput := TransactionInput{[]byte{}, -1, nil, []byte(data)}
-	txOutput := NewTransactionOutput(20, to) // a reward of 20 tokens for the first miner
+	txOutput := NewTransactionOutput(value, to)
 
 	tx := Transaction{nil, []TransactionInput{txInput}, []TransactionOutput{*txOutput}}
 	tx.ID = tx.hash()
@@ -79,11 +91,25 @@ func CoinbaseTx(to, data string) *Transaction {
 
 // create a new transaction
 func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
+	return NewTransactionWithFee(w, to, amount, 0, UTXO)
+}
+
+// create a new transaction that leaves a fee to the miner including it
+func NewTransactionWithFee(w *wallet.Wallet, to string, amount, fee int, UTXO *UTXOSet) *Transaction {
+	outputs := []TransactionOutput{*NewTransactionOutput(amount, to)}
+
+	return newSpendingTransaction(w, amount, fee, outputs, UTXO)
+}
+
+// create a signed transaction that funds the given outputs, worth amount, and the fee with the wallet's unspent outputs
+func newSpendingTransaction(w *wallet.Wallet, amount, fee int, outputs []TransactionOutput, UTXO *UTXOSet) *Transaction {
 	var inputs []TransactionInput
-	var outputs []TransactionOutput
 
 	publicKeyHash := wallet.PublicKeyHash(w.PublicKey)
 
+	// whatever the outputs do not claim is left to the miner
+	amount += fee
+
 	acc, validOutputs := UTXO.FindSpendableOutputs(publicKeyHash, amount)
 
 	if acc < amount {
@@ -101,7 +127,6 @@ func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Tra
 	}
 
 	from := fmt.Sprintf("%s", w.Address())
-	outputs = append(outputs, *NewTransactionOutput(amount, to))
 
 	// if we have tokens leftover, we need to point them to ourselves
 	if acc > amount {
@@ -133,33 +158,10 @@ func (tx *Transaction) sign(privateKey ecdsa.PrivateKey, previousTXs map[string]
 		}
 	}
 
-	// create a copy of the transaction, and populate it with the previous transactions
-	// this is done because we need to modify the transaction in order to sign it
-	txCopy := tx.trimmedCopy()
-
-	for inId, in := range txCopy.Inputs {
-		previousTX := previousTXs[hex.EncodeToString(in.ID)]
-
-		// clear signature and set public key to the previous output's public key hash
-		// this recreates the state of the transaction at signing time
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PublicKey = previousTX.Outputs[in.Output].PublicKeyHash
-
-		// calculate the hash of this state
-		txCopy.ID = txCopy.hash()
-
-		// clear the public key as it's no longer needed
-		txCopy.Inputs[inId].PublicKey = nil
-
-		// sign the hash using the private key
-		r, s, err := ecdsa.Sign(rand.Reader, &privateKey, txCopy.ID)
+	// every input commits to the whole transaction, see signInput for the other hash types
+	for inId := range tx.Inputs {
+		err := tx.signInput(inId, privateKey, previousTXs, SigHashAll)
 		Handle(err)
-
-		// combine the signature components (r,s) into a single byte slice
-		signature := append(r.Bytes(), s.Bytes()...)
-
-		// store the signature in the actual transaction
-		tx.Inputs[inId].Signature = signature
 	}
 }
 
@@ -182,55 +184,9 @@ func (tx *Transaction) trimmedCopy() Transaction {
 	return txCopy
 }
 
-// verify a transaction using the public key
+// verify a transaction using the public key, see CheckInputs
 func (tx *Transaction) Verify(previousTXs map[string]Transaction) bool {
-	// coinbase transactions are always valid
-	if tx.isCoinbase() {
-		return true
-	}
-
-	// verify that every previous transactions exist
-	for _, in := range tx.Inputs {
-		if previousTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction does not exist")
-		}
-	}
-
-	// create a copy of the transaction, and populate it with the previous transactions
-	// this is done because we need to modify the transaction in order to verify it
-	txCopy := tx.trimmedCopy()
-	curve := elliptic.P256()
-
-	for inId, in := range txCopy.Inputs {
-		// recreate the same state as when the transaction was signed
-		previousTX := previousTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PublicKey = previousTX.Outputs[in.Output].PublicKeyHash
-		txCopy.ID = txCopy.hash()
-		txCopy.Inputs[inId].PublicKey = nil
-
-		// deconstruct the signature into its components
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-
-		// deconstruct the public key into its coordinates
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PublicKey)
-		x.SetBytes(in.PublicKey[:(keyLen / 2)])
-		y.SetBytes(in.PublicKey[(keyLen / 2):])
-
-		// verify the signature
-		rawPublicKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPublicKey, txCopy.ID, &r, &s) == false {
-			return false
-		}
-	}
-
-	return true
+	return CheckInputs(tx, previousTXs) == nil
 }
 
 // stringify the transaction
@@ -250,6 +206,10 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
 		lines = append(lines, fmt.Sprintf("       Script: %x", output.PublicKeyHash))
+		if output.IsData() {
+			kind, payload := output.payload()
+			lines = append(lines, fmt.Sprintf("       Data:   %d %x", kind, payload))
+		}
 	}
 
 	return strings.Join(lines, "\n")
@@ -20,6 +20,16 @@ type TransactionOutput struct {
 
 type TransactionOutputs struct {
 	Outputs []TransactionOutput
+	Indices []int // the index of each output in its transaction, as spent outputs leave gaps
+}
+
+// the index an output has in its transaction
+// entries written before indices were stored hold every output, so their position is their index
+func (outs TransactionOutputs) index(position int) int {
+	if len(outs.Indices) == 0 {
+		return position
+	}
+	return outs.Indices[position]
 }
 
 func NewTransactionOutput(value int, address string) *TransactionOutput {
@@ -41,6 +51,10 @@ func (out *TransactionOutput) lock(address []byte) {
 
 // check if the output is locked with the given public key hash
 func (out *TransactionOutput) isLockedWithKey(publicKeyHash []byte) bool {
+	// data outputs are not owned by anyone, so they can never be spent
+	if out.IsData() {
+		return false
+	}
 	return bytes.Compare(out.PublicKeyHash, publicKeyHash) == 0
 }
 
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"log"
 	"slices"
+	"strconv"
 
 	"github.com/dgraph-io/badger"
 )
@@ -15,14 +16,17 @@ var (
 )
 
 type UTXOSet struct {
-	Blockchain *BlockChain // refenrece a Blockchain for its inclusion of a database pointer
+	Blockchain *BlockChain     // refenrece a Blockchain for its inclusion of a database pointer
+	Frozen     map[string]bool // TXID:INDEX of the coins automatic coin selection must leave alone
 }
 
 // retrieve amount of tokens aswell as the transactions' IDs whose outputs concern the address' recipient
+// frozen coins and immature coinbase outputs are never picked
 func (u *UTXOSet) FindSpendableOutputs(publicKeyHash []byte, amountToSend int) (int, map[string][]int) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
 	db := u.Blockchain.Database
+	immature := u.Blockchain.immatureCoinbases(publicKeyHash)
 
 	err := db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
@@ -45,10 +49,14 @@ func (u *UTXOSet) FindSpendableOutputs(publicKeyHash []byte, amountToSend int) (
 			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(val)
 
-			for outIdx, out := range outs.Outputs {
-				if out.isLockedWithKey(publicKeyHash) && accumulated < amountToSend {
+			if immature[txID] {
+				continue
+			}
+
+			for position, out := range outs.Outputs {
+				if out.isLockedWithKey(publicKeyHash) && accumulated < amountToSend && !u.Frozen[txID+":"+strconv.Itoa(outs.index(position))] {
 					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+					unspentOutputs[txID] = append(unspentOutputs[txID], outs.index(position))
 				}
 			}
 		}
@@ -118,87 +126,14 @@ func (u *UTXOSet) CountTransactions() int {
 	return counter
 }
 
+// rebuild the UTXO set, along with every other index derived from the blocks
 func (u *UTXOSet) Reindex() {
-	db := u.Blockchain.Database
-
-	u.deleteByPrefix(UTXOPrefix)
-
-	UTXO := u.Blockchain.FindUnspentTransactions()
-
-	err := db.Update(func(txn *badger.Txn) error {
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
-			if err != nil {
-				return err
-			}
-			key = append(UTXOPrefix, key...)
-
-			err = txn.Set(key, outs.Serialize())
-			Handle(err)
-		}
-		return nil
-	})
-	Handle(err)
-}
-
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
-
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if !tx.isCoinbase() {
-				for _, in := range tx.Inputs {
-					updatedOutputs := TransactionOutputs{}
-					inID := append(UTXOPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					Handle(err)
-					var val []byte
-					err = item.Value(func(v []byte) error {
-						// this func with val would only be called if item.Value() encounters no error.
-						val = slices.Clone(v)
-						return nil
-					})
-					Handle(err)
-
-					outs := DeserializeOutputs(val)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Output {
-							updatedOutputs.Outputs = append(updatedOutputs.Outputs, out)
-						}
-					}
-
-					if len(updatedOutputs.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-					} else {
-						if err := txn.Set(inID, updatedOutputs.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
-				}
-			}
-			newOutputs := TransactionOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
-
-			txID := append(UTXOPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
-			}
-		}
-
-		return nil
-	})
-
-	Handle(err)
+	u.Blockchain.reindex()
 }
 
-func (u *UTXOSet) deleteByPrefix(prefix []byte) {
+func (chain *BlockChain) deleteByPrefix(prefix []byte) {
 	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		if err := chain.Database.Update(func(txn *badger.Txn) error {
 			for _, key := range keysForDelete {
 				if err := txn.Delete(key); err != nil {
 					return err
@@ -213,7 +148,7 @@ func (u *UTXOSet) deleteByPrefix(prefix []byte) {
 
 	// the number of keys to delete at a time
 	collectionSize := 100000
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
+	chain.Database.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchValues = false
 		it := txn.NewIterator(opts)
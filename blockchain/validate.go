@@ -0,0 +1,224 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang-blockchain/wallet"
+	"math/big"
+)
+
+// the stateless part of consensus: every check below takes what it needs as arguments and never touches the
+// database, so it can be fed arbitrary input by fuzzers and property tests, and used by clients that only hold
+// headers and proofs, the callers reading the chain look up the previous transactions and the difficulty first
+// errors wrap one of the sentinels below, so a caller can tell what kind of object was rejected
+var (
+	ErrInvalidHeader      = errors.New("invalid header")
+	ErrInvalidBlock       = errors.New("invalid block")
+	ErrInvalidTransaction = errors.New("invalid transaction")
+)
+
+const txIDLength = sha256.Size
+
+// check that a header's hash is the one its fields produce, that it meets the target of the difficulty, and
+// that only a genesis header lacks a parent
+func CheckHeader(h *BlockHeader, difficulty int) error {
+	if difficulty < 1 || difficulty > 255 {
+		return fmt.Errorf("%w: difficulty %d is out of range", ErrInvalidHeader, difficulty)
+	}
+	if h.Height < 0 {
+		return fmt.Errorf("%w: negative height %d", ErrInvalidHeader, h.Height)
+	}
+	if (len(h.PrevHash) == 0) != (h.Height == 0) {
+		return fmt.Errorf("%w: only the genesis block at height 0 has no parent", ErrInvalidHeader)
+	}
+
+	hash := sha256.Sum256(powData(h.PrevHash, h.MerkleRoot, h.Nonce, difficulty))
+	if !bytes.Equal(hash[:], h.Hash) {
+		return fmt.Errorf("%w: hash %x does not match its fields", ErrInvalidHeader, h.Hash)
+	}
+
+	var intHash big.Int
+	intHash.SetBytes(hash[:])
+	if intHash.Cmp(NewProof(nil, difficulty).Target) != -1 {
+		return fmt.Errorf("%w: hash %x does not meet the proof-of-work target", ErrInvalidHeader, h.Hash)
+	}
+
+	return nil
+}
+
+// check a sequence of headers, oldest first, each one valid and built on the one before it
+func CheckHeaderChain(headers []BlockHeader, difficulty int) error {
+	for i := range headers {
+		if err := CheckHeader(&headers[i], difficulty); err != nil {
+			return err
+		}
+		if i == 0 {
+			continue
+		}
+
+		if !bytes.Equal(headers[i].PrevHash, headers[i-1].Hash) || headers[i].Height != headers[i-1].Height+1 {
+			return fmt.Errorf("%w: headers do not form a chain at %x", ErrInvalidHeader, headers[i].Hash)
+		}
+	}
+
+	return nil
+}
+
+// check what a block shows on its own: its header, that it holds exactly one coinbase, that each of its
+// transactions is well formed and that no two of them claim the same bridge transfer, whether they spend coins
+// that exist is up to the chain
+func CheckBlock(b *Block, difficulty int) error {
+	if len(b.Transactions) == 0 {
+		return fmt.Errorf("%w: block %x holds no transactions", ErrInvalidBlock, b.Hash)
+	}
+
+	coinbases := 0
+	seen := make(map[string]bool)
+	claimed := make(map[string]bool)
+	for _, tx := range b.Transactions {
+		if tx == nil {
+			return fmt.Errorf("%w: block %x holds an empty transaction", ErrInvalidBlock, b.Hash)
+		}
+		if err := CheckTransaction(tx); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+		}
+
+		if tx.isCoinbase() {
+			coinbases++
+		}
+		if seen[string(tx.ID)] {
+			return fmt.Errorf("%w: transaction %x appears twice", ErrInvalidBlock, tx.ID)
+		}
+		seen[string(tx.ID)] = true
+
+		if key := tx.claimedTransfer(); key != "" {
+			if claimed[key] {
+				return fmt.Errorf("%w: transfer %s is claimed twice", ErrInvalidBlock, key)
+			}
+			claimed[key] = true
+		}
+	}
+	if coinbases != 1 {
+		return fmt.Errorf("%w: block %x holds %d coinbase transactions", ErrInvalidBlock, b.Hash, coinbases)
+	}
+
+	// the header is built from the transactions, so its hash commits to them through the merkle root
+	header := b.Header()
+	if err := CheckHeader(&header, difficulty); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+
+	return nil
+}
+
+// check the structure of a transaction: it has an ID, outputs of non-negative value, well formed metadata, and
+// inputs that each spend a distinct output, only coinbases and bridge claims create coins without inputs
+func CheckTransaction(tx *Transaction) error {
+	if len(tx.ID) != txIDLength {
+		return fmt.Errorf("%w: ID %x is not %d bytes long", ErrInvalidTransaction, tx.ID, txIDLength)
+	}
+	if len(tx.Outputs) == 0 {
+		return fmt.Errorf("%w: %x has no outputs", ErrInvalidTransaction, tx.ID)
+	}
+
+	total := 0
+	for i, out := range tx.Outputs {
+		if out.Value < 0 {
+			return fmt.Errorf("%w: output %d of %x has a negative value", ErrInvalidTransaction, i, tx.ID)
+		}
+		if total += out.Value; total < 0 {
+			return fmt.Errorf("%w: the outputs of %x overflow", ErrInvalidTransaction, tx.ID)
+		}
+	}
+	if err := tx.checkMetadata(); err != nil {
+		return fmt.Errorf("%w: %x carries malformed metadata: %v", ErrInvalidTransaction, tx.ID, err)
+	}
+
+	if tx.isCoinbase() {
+		return nil
+	}
+	if len(tx.Inputs) == 0 && !tx.isBridgeClaim() {
+		return fmt.Errorf("%w: %x has no inputs", ErrInvalidTransaction, tx.ID)
+	}
+
+	spent := make(map[string]bool)
+	for i, in := range tx.Inputs {
+		if len(in.ID) != txIDLength || in.Output < 0 {
+			return fmt.Errorf("%w: input %d of %x refers to no output", ErrInvalidTransaction, i, tx.ID)
+		}
+		if len(in.PublicKey) == 0 || len(in.PublicKey)%2 != 0 {
+			return fmt.Errorf("%w: input %d of %x carries a malformed public key", ErrInvalidTransaction, i, tx.ID)
+		}
+
+		key := outpoint(in)
+		if spent[key] {
+			return fmt.Errorf("%w: %x spends %s twice", ErrInvalidTransaction, tx.ID, key)
+		}
+		spent[key] = true
+	}
+
+	return nil
+}
+
+// evaluate the inputs of a well formed transaction against the outputs they spend: each input must carry the
+// key the spent output is locked to and a signature of that key over the parts its hash type commits to
+// previousTXs holds every transaction the inputs refer to, keyed by their hex encoded ID
+func CheckInputs(tx *Transaction, previousTXs map[string]Transaction) error {
+	if tx.isCoinbase() {
+		return nil
+	}
+
+	for inId := range tx.Inputs {
+		if err := checkInput(tx, inId, previousTXs); err != nil {
+			return fmt.Errorf("%w: input %d of %x: %w", ErrInvalidTransaction, inId, tx.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func checkInput(tx *Transaction, inId int, previousTXs map[string]Transaction) error {
+	in := tx.Inputs[inId]
+
+	previousTX, ok := previousTXs[hex.EncodeToString(in.ID)]
+	if !ok {
+		return fmt.Errorf("previous transaction %x is unknown", in.ID)
+	}
+	if in.Output < 0 || in.Output >= len(previousTX.Outputs) {
+		return fmt.Errorf("previous transaction %x has no output %d", in.ID, in.Output)
+	}
+
+	spent := previousTX.Outputs[in.Output]
+	if spent.IsData() {
+		return errors.New("data outputs cannot be spent")
+	}
+	if !bytes.Equal(wallet.PublicKeyHash(in.PublicKey), spent.PublicKeyHash) {
+		return errors.New("the key does not unlock the spent output")
+	}
+
+	// every input carries the hash type it was signed with, so each one recreates its own signed state
+	r, s, hashType, legacy := decodeSignature(in.Signature)
+	digest, err := tx.sigHash(inId, previousTXs, hashType, legacy)
+	if err != nil {
+		return err
+	}
+
+	// deconstruct the public key into its coordinates
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(in.PublicKey)
+	x.SetBytes(in.PublicKey[:(keyLen / 2)])
+	y.SetBytes(in.PublicKey[(keyLen / 2):])
+
+	rawPublicKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+	if !ecdsa.Verify(&rawPublicKey, digest, r, s) {
+		return errors.New("the signature does not verify")
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"golang-blockchain/wallet"
+	"math/big"
+	"testing"
+)
+
+// every hash type a signature can be made with
+var sigHashTypes = []SigHashType{
+	SigHashAll, SigHashNone, SigHashSingle,
+	SigHashAll | SigHashAnyoneCanPay, SigHashNone | SigHashAnyoneCanPay, SigHashSingle | SigHashAnyoneCanPay,
+}
+
+// a transaction spending both outputs of a previous transaction locked to the wallet, unsigned
+func spendingFixture(w *wallet.Wallet) (*Transaction, map[string]Transaction) {
+	publicKeyHash := wallet.PublicKeyHash(w.PublicKey)
+
+	previous := Transaction{
+		Inputs:  []TransactionInput{{[]byte{}, -1, nil, []byte("fixture")}},
+		Outputs: []TransactionOutput{{10, publicKeyHash}, {20, publicKeyHash}},
+	}
+	previous.ID = previous.hash()
+
+	tx := &Transaction{
+		Inputs: []TransactionInput{
+			{previous.ID, 0, nil, w.PublicKey},
+			{previous.ID, 1, nil, w.PublicKey},
+		},
+		Outputs: []TransactionOutput{*NewTransactionOutput(25, string(wallet.MakeWallet().Address())), {4, publicKeyHash}},
+	}
+	tx.ID = tx.hash()
+
+	return tx, map[string]Transaction{hex.EncodeToString(previous.ID): previous}
+}
+
+// a signed transaction and a mined block holding it, the seeds of the fuzzers
+func validFixture(t testing.TB) (*Transaction, *Block) {
+	w := wallet.MakeWallet()
+	tx, previousTXs := spendingFixture(w)
+	for inId := range tx.Inputs {
+		if err := tx.signInput(inId, w.PrivateKey, previousTXs, SigHashAll); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	coinbase := newCoinbaseTx(string(w.Address()), "fixture", regtestParams.Reward(1))
+	block := createBlockAt([]*Transaction{coinbase, tx}, []byte("parent"), 1, regtestParams.Difficulty, 1)
+
+	return tx, block
+}
+
+func FuzzCheckTransaction(f *testing.F) {
+	tx, block := validFixture(f)
+	f.Add(tx.Serialize())
+	f.Add(block.Transactions[0].Serialize())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tx, err := deserializeTransaction(data)
+		if err != nil {
+			return
+		}
+
+		err = CheckTransaction(tx)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidTransaction) {
+				t.Fatalf("rejected with an error that is not ErrInvalidTransaction: %v", err)
+			}
+			return
+		}
+
+		// the checks depend on nothing but the transaction, so an accepted transaction survives a round trip
+		decoded, err := deserializeTransaction(tx.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckTransaction(decoded); err != nil {
+			t.Fatalf("accepted transaction rejected after a round trip: %v", err)
+		}
+
+		// and evaluating its inputs against nothing fails instead of panicking
+		if len(tx.Inputs) > 0 && !tx.isCoinbase() {
+			if err := CheckInputs(tx, map[string]Transaction{}); err == nil {
+				t.Fatal("inputs spending unknown transactions were accepted")
+			}
+		}
+	})
+}
+
+func FuzzCheckBlock(f *testing.F) {
+	_, block := validFixture(f)
+	f.Add(block.Serialize())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		block, err := deserializeBlock(data)
+		if err != nil {
+			return
+		}
+
+		err = CheckBlock(block, regtestParams.Difficulty)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidBlock) {
+				t.Fatalf("rejected with an error that is not ErrInvalidBlock: %v", err)
+			}
+			return
+		}
+
+		// the header commits to every transaction, so changing one turns the block away
+		for _, tx := range block.Transactions {
+			if tx.isCoinbase() {
+				tx.Outputs[0].Value++
+				break
+			}
+		}
+		if CheckBlock(block, regtestParams.Difficulty) == nil {
+			t.Fatal("block with a changed coinbase was accepted")
+		}
+	})
+}
+
+// a signature decodes into the components and hash type it was made with, and those verify again
+func TestDecodeSignatureRoundTrip(t *testing.T) {
+	w := wallet.MakeWallet()
+
+	for _, hashType := range sigHashTypes {
+		for range 10 {
+			tx, previousTXs := spendingFixture(w)
+			if err := tx.signInput(0, w.PrivateKey, previousTXs, hashType); err != nil {
+				t.Fatal(err)
+			}
+
+			signature := tx.Inputs[0].Signature
+			r, s, decodedType, legacy := decodeSignature(signature)
+			if legacy || decodedType != hashType {
+				t.Fatalf("%s signature decoded as %s, legacy %v", hashType, decodedType, legacy)
+			}
+
+			encoded := make([]byte, signatureLength)
+			r.FillBytes(encoded[:sigComponentLength])
+			s.FillBytes(encoded[sigComponentLength : 2*sigComponentLength])
+			encoded[signatureLength-1] = byte(decodedType)
+			if !bytes.Equal(encoded, signature) {
+				t.Fatalf("%s signature %x encodes back as %x", hashType, signature, encoded)
+			}
+
+			digest, err := tx.sigHash(0, previousTXs, decodedType, legacy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			publicKey := w.PrivateKey.PublicKey
+			if !ecdsa.Verify(&publicKey, digest, r, s) {
+				t.Fatalf("decoded %s signature does not verify", hashType)
+			}
+
+			if err := checkInput(tx, 0, previousTXs); err != nil {
+				t.Fatalf("%s signature rejected: %v", hashType, err)
+			}
+		}
+	}
+
+	// signatures made before hash types existed are r and s without padding and commit to everything,
+	// they are split in the middle, so only the ones with components of equal length ever verified
+	tx, previousTXs := spendingFixture(w)
+	for inId := range tx.Inputs {
+		digest, err := tx.sigHash(inId, previousTXs, SigHashAll, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r, s *big.Int
+		for r == nil || len(r.Bytes()) != len(s.Bytes()) {
+			if r, s, err = ecdsa.Sign(rand.Reader, &w.PrivateKey, digest); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tx.Inputs[inId].Signature = append(r.Bytes(), s.Bytes()...)
+
+		decodedR, decodedS, hashType, legacy := decodeSignature(tx.Inputs[inId].Signature)
+		if !legacy || hashType != SigHashAll || decodedR.Cmp(r) != 0 || decodedS.Cmp(s) != 0 {
+			t.Fatalf("legacy signature decoded as %s, legacy %v", hashType, legacy)
+		}
+	}
+	if err := CheckInputs(tx, previousTXs); err != nil {
+		t.Fatalf("legacy signatures rejected: %v", err)
+	}
+
+	// a signature altered in any byte no longer verifies
+	tx.Inputs[0].Signature[len(tx.Inputs[0].Signature)-1] ^= 1
+	if CheckInputs(tx, previousTXs) == nil {
+		t.Fatal("altered legacy signature was accepted")
+	}
+}
+
+// sign an input with every hash type, then check that the signature holds against changes to what it leaves out
+// and breaks on changes to what it commits to
+func TestSignAndVerifyEverySigHashType(t *testing.T) {
+	w := wallet.MakeWallet()
+	other := wallet.MakeWallet()
+
+	for _, hashType := range sigHashTypes {
+		t.Run(hashType.String(), func(t *testing.T) {
+			signed := func() (*Transaction, map[string]Transaction) {
+				tx, previousTXs := spendingFixture(w)
+				if err := tx.signInput(0, w.PrivateKey, previousTXs, hashType); err != nil {
+					t.Fatal(err)
+				}
+				if err := checkInput(tx, 0, previousTXs); err != nil {
+					t.Fatalf("signature rejected: %v", err)
+				}
+				return tx, previousTXs
+			}
+
+			// what each change does to the signature of input 0
+			changes := []struct {
+				name      string
+				change    func(tx *Transaction)
+				committed bool
+			}{
+				{"value of its own output", func(tx *Transaction) { tx.Outputs[0].Value++ }, hashType.base() != SigHashNone},
+				{"value of another output", func(tx *Transaction) { tx.Outputs[1].Value++ }, hashType.base() == SigHashAll},
+				{"an added output", func(tx *Transaction) {
+					tx.Outputs = append(tx.Outputs, *NewTransactionOutput(1, string(other.Address())))
+				}, hashType.base() == SigHashAll},
+				{"another input", func(tx *Transaction) { tx.Inputs[1].Output = 0 }, hashType&SigHashAnyoneCanPay == 0},
+				{"its own input", func(tx *Transaction) { tx.Inputs[0].Output = 1 }, true},
+			}
+
+			for _, c := range changes {
+				tx, previousTXs := signed()
+				c.change(tx)
+
+				err := checkInput(tx, 0, previousTXs)
+				if c.committed && err == nil {
+					t.Errorf("changing the %s kept the signature valid", c.name)
+				}
+				if !c.committed && err != nil {
+					t.Errorf("changing the %s broke the signature: %v", c.name, err)
+				}
+			}
+
+			// a signature only verifies under the key it was made with
+			tx, previousTXs := signed()
+			tx.Inputs[0].PublicKey = other.PublicKey
+			if checkInput(tx, 0, previousTXs) == nil {
+				t.Error("signature verified under another key")
+			}
+		})
+	}
+}
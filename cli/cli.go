@@ -1,12 +1,18 @@
 package cli
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"golang-blockchain/blockchain"
 	"golang-blockchain/network"
@@ -17,89 +23,428 @@ type CommandLine struct{}
 
 func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage: ")
-	fmt.Println("   getbalance -address ADDRESS —— get the balance for the given ADDRESS")
-	fmt.Println("   createblockchain -address ADDRESS —— create a fresh blockchain and have the ADDRESS mine the genesis block")
-	fmt.Println("   send -from FROM -to TO -amount AMOUNT -mine —— Send amount of coins. If -mine flag is set, mine off of this node")
+	fmt.Println("   getbalance -address ADDRESS -chain CHAIN —— get the balance for the given ADDRESS")
+	fmt.Println("   createblockchain -address ADDRESS -chain CHAIN -params FILE —— create a fresh blockchain and have the ADDRESS mine the genesis block. If -params is set, create the network the JSON FILE defines instead")
+	fmt.Println("   chainparams -chain CHAIN —— print the parameters the chain was created with")
+	fmt.Println("   send -from FROM -to TO|NAME -amount AMOUNT -fee FEE -meta KEY=VALUE&... -coins TXID:INDEX,... -change ADDRESS -mine —— Send amount of coins. If -mine flag is set, mine off of this node. If -coins is set, spend exactly those coins and send the change to -change")
 	fmt.Println("   printchain —— prints the blocks in the blockchain")
 	fmt.Println("   createwallet —— create a new wallet")
 	fmt.Println("   listaddresses —— list the addresses in the wallet file")
 	fmt.Println("   reindexutxo —— rebuild the UTXO set")
-	fmt.Println("   startnode -miner ADDRESS —— Start a node with ID specified in NODE_ID .env variable; miner enables mining")
+	fmt.Println("   listunspent -address ADDRESS —— list the unspent coins of the address, or of every address in the wallet file")
+	fmt.Println("   listtransactions -address ADDRESS -chain CHAIN —— list the transactions of the address with their confirmations, newest first")
+	fmt.Println("   freeze -coins TXID:INDEX,... —— keep the coins out of automatic coin selection")
+	fmt.Println("   unfreeze -coins TXID:INDEX,... —— let automatic coin selection spend the coins again")
+	fmt.Println("   chainstats -chain CHAIN —— print the totals of the main chain")
+	fmt.Println("   anchor -file FILE | -hash HASH -from FROM -mine —— timestamp the file's SHA-256 digest, or the given hash, in a data output")
+	fmt.Println("   anchorproof -tx TXID -out FILE —— write the proof that the transaction's hash was anchored to FILE")
+	fmt.Println("   verifyanchor -in FILE -file FILE | -hash HASH —— check an anchor proof and print the time the hash existed before")
+	fmt.Println("   registername -name NAME -from FROM -renew -mine —— register a name for the address, or renew it if -renew is set")
+	fmt.Println("   lookupname -name NAME —— print the owner and expiry of a registered name")
+	fmt.Println("   propose -from FROM -param PARAM -value VALUE -title TITLE -mine —— propose a new value for maxblocksize or minfee")
+	fmt.Println("   vote -from FROM -proposal ID -no -mine —— vote for the proposal with the address's coins, or against it if -no is set")
+	fmt.Println("   tally -proposal ID —— print the votes of the proposal, or of every proposal")
+	fmt.Println("   recordfixture -out FILE -chain CHAIN —— record the chain and its current state, including the wallets' balances, as a replay fixture")
+	fmt.Println("   replay -in FILE —— execute the blocks of a fixture on a scratch database and check the state they lead to")
+	fmt.Println("   simulate -wallets N -tps RATE -interval DURATION -blocks N -fee FEE -difficulty BITS —— drive synthetic wallets against a scratch regtest chain and report throughput, validation latency and database growth")
+	fmt.Println("   searchtx -query KEY=VALUE&... -chain CHAIN —— find the transactions whose metadata matches the query, VALUE may end with *")
+	fmt.Println("   backup -out NAME -online HOST:PORT —— back up the chain database to NAME in the node's backup directory ./tmp/backups_NODE_ID. If -online is set, ask the running node serving JSON-RPC there to do it")
+	fmt.Println("   restore -in FILE —— restore the chain database from a backup FILE")
+	fmt.Println("   exportwallets -out FILE —— export the wallets to a bundle FILE")
+	fmt.Println("   importwallets -in FILE —— import the wallets of a bundle FILE")
+	fmt.Println("   bridge -from FROM -to TO -amount AMOUNT -dest CHAIN -chain CHAIN -burn -mine —— lock AMOUNT coins for TO on the DEST chain. If -burn flag is set, burn mirrored coins instead")
+	fmt.Println("   bridgeproof -tx TXID -out FILE -chain CHAIN —— write the SPV proof of a confirmed bridge transfer to FILE")
+	fmt.Println("   bridgeclaim -in FILE -chain CHAIN -miner ADDRESS —— claim the coins of a bridge proof FILE. If -miner is set, mine the claim off of this node")
+	fmt.Println("   bridgetrack -source CHAIN -chain CHAIN —— copy the latest headers of the SOURCE chain into CHAIN, claims are only accepted for transfers confirmed in them")
+	fmt.Println("   (-chain defaults to the main chain)")
+	fmt.Println("   startnode -miner ADDRESS -advertise HOST:PORT -publish HOST:PORT -rpc HOST:PORT -config FILE -checkchain —— Start a node with ID specified in NODE_ID .env variable; miner enables mining, publish streams accepted blocks and transactions, rpc serves JSON-RPC, config reads the relay policy and log level from a JSON file that SIGHUP or the reloadconfig method reloads, checkchain checks every block before starting, which only happens after an unclean shutdown otherwise")
+	fmt.Println("   rpc -address HOST:PORT -method METHOD -params JSON —— call a method of a node's JSON-RPC interface, such as listbroadcasts, gettransactionstatus [TXID], getblocks [[HASH,...],VERBOSE] getutxos [[ADDRESS,...]], getbalances [ADDRESS], listtransactions [ADDRESS], getaddressinfo [ADDRESS], getconfig, reloadconfig or backup [NAME]. Requests POSTed as a JSON array are answered as a batch")
+	fmt.Println("   subscribe -address HOST:PORT -topic TOPIC,... —— print the events a node publishes: rawblock, rawtx, hashblock, hashtx, all of them by default")
+	fmt.Println("   NODE_PROXY=HOST:PORT routes every outbound connection through a SOCKS5 proxy such as Tor, NODE_PEERS=HOST:PORT,... replaces the default peers; both accept .onion addresses")
 }
 
-func (cli *CommandLine) getBalance(address string, nodeID string) {
+func (cli *CommandLine) getBalance(address, chainID, nodeID string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("Address is invalid")
 	}
 
-	chain := blockchain.ContinueBlockChain(nodeID)
-	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	defer chain.Database.Close()
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
 
-	amount := 0
 	publicKeyHash := wallet.Base58Decode([]byte(address))
 	publicKeyHash = publicKeyHash[1 : len(publicKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(publicKeyHash)
-
-	for _, UTXO := range UTXOs {
-		amount += UTXO.Value
-	}
+	amount := chain.GetBalance(publicKeyHash)
 
 	fmt.Printf("--------\n")
 	fmt.Printf("Address %s has %d tokens\n", address, amount)
+	if balance := chain.WalletBalance(publicKeyHash, nil); balance.Immature > 0 {
+		fmt.Printf("%d of them are immature coinbase rewards\n", balance.Immature)
+	}
 	fmt.Printf("--------\n")
 }
 
-func (cli *CommandLine) createBlockChain(address, nodeID string) {
+// the transactions of an address with their confirmations, newest first
+func (cli *CommandLine) listTransactions(address, chainID, nodeID string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("Address is invalid")
 	}
 
-	chain := blockchain.CreateBlockChain(address, nodeID)
-	chain.Database.Close()
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
 
-	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	publicKeyHash := wallet.Base58Decode([]byte(address))
+	publicKeyHash = publicKeyHash[1 : len(publicKeyHash)-4]
+
+	for _, tx := range chain.WalletTransactions(publicKeyHash, nil) {
+		view := network.NewWalletTransactionView(tx, chain.Params)
+		if view.Status == network.TransactionUnconfirmed {
+			fmt.Printf("%s %s\n", view.TxID, view.Status)
+			continue
+		}
+		fmt.Printf("%s %s at height %d, %d confirmations\n", view.TxID, view.Status, view.Height, view.Confirmations)
+	}
+}
+
+func (cli *CommandLine) createBlockChain(address, chainID, paramsFile, nodeID string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is invalid")
+	}
+
+	// a parameters file names the chain it defines
+	params := blockchain.ParamsFor(chainID)
+	if paramsFile != "" {
+		var err error
+		params, err = blockchain.LoadParams(paramsFile)
+		blockchain.Handle(err)
+	}
+
+	chain := blockchain.CreateChainWithParams(address, params, nodeID)
+	chain.Close()
 
 	fmt.Println("blockchain created!")
 }
 
-func (cli *CommandLine) send(from, to string, amount int, nodeID string, mineNow bool) {
+func (cli *CommandLine) send(from, to string, amount, fee int, meta, coins, change, nodeID string, mineNow bool) {
 	if !wallet.ValidateAddress(from) {
 		log.Panic("Address is invalid")
 	}
 
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	// coins can be sent to a registered name instead of an address
+	name := ""
+	if blockchain.ValidateName(to) {
+		address, err := chain.ResolveName(to)
+		blockchain.Handle(err)
+		name, to = to, address
+	}
+
 	if !wallet.ValidateAddress(to) {
 		log.Panic("Address is invalid")
 	}
 
+	wallets, err := wallet.CreateWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	UTXOSet.Frozen = wallets.Frozen
+	wallet := wallets.GetWallet(from)
+
+	var tx *blockchain.Transaction
+	switch {
+	case coins != "":
+		// coin control: spend exactly the given coins, the change goes wherever the user wants it
+		outputs := []blockchain.TransactionOutput{*blockchain.NewTransactionOutput(amount, to)}
+		if meta != "" {
+			metadataOutput, err := blockchain.NewMetadataOutput(parseMetadata(meta))
+			blockchain.Handle(err)
+			outputs = append(outputs, *metadataOutput)
+		}
+		tx, err = blockchain.NewTransactionFromCoins(&wallet, strings.Split(coins, ","), fee, outputs, change, &UTXOSet)
+		blockchain.Handle(err)
+	case change != "":
+		log.Panic("A change address needs the coins to spend")
+	case meta == "":
+		tx = blockchain.NewTransactionWithFee(&wallet, to, amount, fee, &UTXOSet)
+	default:
+		tx, err = blockchain.NewTransactionWithMetadata(&wallet, to, amount, fee, parseMetadata(meta), &UTXOSet)
+		blockchain.Handle(err)
+	}
+
+	if mineNow {
+		// mining our own transaction hands its fee straight back to us
+		cbTx := chain.NewCoinbaseTx(from, "", fee)
+		txs := []*blockchain.Transaction{cbTx, tx}
+		chain.MineBlock(txs)
+	} else {
+		err := network.BroadcastTransaction(nodeID, chain, tx)
+		blockchain.Handle(err)
+		fmt.Println("Sent transaction")
+	}
+
+	if name != "" {
+		fmt.Printf("Sent %d tokens to %s (%s)\n", amount, name, to)
+	} else {
+		fmt.Printf("Sent %d tokens to %s\n", amount, to)
+	}
+}
+
+func (cli *CommandLine) registerName(name, from string, renew bool, nodeID string, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is invalid")
+	}
+
 	chain := blockchain.ContinueBlockChain(nodeID)
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	defer chain.Database.Close()
+	defer chain.Close()
 
 	wallets, err := wallet.CreateWallets(nodeID)
-	if err != nil {
-		log.Panic(err)
+	blockchain.Handle(err)
+	UTXOSet.Frozen = wallets.Frozen
+	wallet := wallets.GetWallet(from)
+
+	op := blockchain.NameRegister
+	if renew {
+		op = blockchain.NameRenew
+	}
+
+	tx, err := chain.NewNameTransaction(&wallet, op, name, &UTXOSet)
+	blockchain.Handle(err)
+
+	if mineNow {
+		cbTx := chain.NewCoinbaseTx(from, "", blockchain.NameFee)
+		txs := []*blockchain.Transaction{cbTx, tx}
+		chain.MineBlock(txs)
+	} else {
+		err := network.BroadcastTransaction(nodeID, chain, tx)
+		blockchain.Handle(err)
+		fmt.Println("Sent transaction")
+	}
+
+	fmt.Printf("%s name %s for %s\n", op, name, from)
+}
+
+func (cli *CommandLine) lookupName(name, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Close()
+
+	record, err := chain.LookupName(name)
+	blockchain.Handle(err)
+
+	fmt.Printf("--------\n")
+	fmt.Printf("Name:       %s\n", record.Name)
+	fmt.Printf("Owner:      %s\n", record.Address(chain.Params))
+	fmt.Printf("Public key: %x\n", record.PublicKey)
+	fmt.Printf("Registered: %d\n", record.Registered)
+	fmt.Printf("Expires:    %d\n", record.Expires)
+	if height := chain.GetStats().Height; height > record.Expires {
+		fmt.Printf("expired, the tip is at block %d\n", height)
+	}
+	fmt.Printf("--------\n")
+}
+
+func (cli *CommandLine) propose(from, title, parameter string, value int, nodeID string, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is invalid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	blockchain.Handle(err)
+	UTXOSet.Frozen = wallets.Frozen
+	wallet := wallets.GetWallet(from)
+
+	tx, err := blockchain.NewProposalTransaction(&wallet, title, parameter, value, &UTXOSet)
+	blockchain.Handle(err)
+
+	if mineNow {
+		cbTx := chain.NewCoinbaseTx(from, "", blockchain.GovernanceFee)
+		txs := []*blockchain.Transaction{cbTx, tx}
+		chain.MineBlock(txs)
+	} else {
+		err := network.BroadcastTransaction(nodeID, chain, tx)
+		blockchain.Handle(err)
+		fmt.Println("Sent transaction")
 	}
+
+	fmt.Printf("Proposal %x: set %s to %d\n", tx.ID, parameter, value)
+}
+
+func (cli *CommandLine) vote(from, proposalID string, yes bool, nodeID string, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is invalid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	blockchain.Handle(err)
+	UTXOSet.Frozen = wallets.Frozen
 	wallet := wallets.GetWallet(from)
 
-	tx := blockchain.NewTransaction(&wallet, to, amount, &UTXOSet)
+	id, err := hex.DecodeString(proposalID)
+	blockchain.Handle(err)
+
+	tx, err := chain.NewVoteTransaction(&wallet, id, yes, &UTXOSet)
+	blockchain.Handle(err)
+
 	if mineNow {
-		cbTx := blockchain.CoinbaseTx(from, "")
+		cbTx := chain.NewCoinbaseTx(from, "", blockchain.GovernanceFee)
 		txs := []*blockchain.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
+		chain.MineBlock(txs)
 	} else {
-		network.SendTransaction(network.KnownNodes[0], tx)
+		err := network.BroadcastTransaction(nodeID, chain, tx)
+		blockchain.Handle(err)
 		fmt.Println("Sent transaction")
 	}
 
-	fmt.Printf("Sent %d tokens to %s\n", amount, to)
+	fmt.Printf("Voted on proposal %s with %s\n", proposalID, from)
+}
+
+// print the tally of a proposal, or of every proposal when no ID is given
+func (cli *CommandLine) tally(proposalID, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Close()
+
+	var ids [][]byte
+	if proposalID != "" {
+		id, err := hex.DecodeString(proposalID)
+		blockchain.Handle(err)
+		ids = append(ids, id)
+	} else {
+		for _, proposal := range chain.Proposals() {
+			ids = append(ids, proposal.ID)
+		}
+	}
+
+	for _, id := range ids {
+		tally, err := chain.Tally(id)
+		blockchain.Handle(err)
+
+		proposal := tally.Proposal
+		status := "open"
+		if tally.Passed {
+			status = fmt.Sprintf("passed, active from block %d", proposal.ActivatesAt())
+		} else if tally.Closed {
+			status = "rejected"
+		}
+
+		fmt.Printf("--------\n")
+		fmt.Printf("Proposal: %x\n", proposal.ID)
+		fmt.Printf("Title:    %s\n", proposal.Proposal.Title)
+		fmt.Printf("Change:   %s = %d\n", proposal.Proposal.Parameter, proposal.Proposal.Value)
+		fmt.Printf("Voting:   blocks %d to %d\n", proposal.Height+1, proposal.VotingEnds())
+		fmt.Printf("Yes:      %d\n", tally.Yes)
+		fmt.Printf("No:       %d\n", tally.No)
+		fmt.Printf("Voters:   %d, holding %d of %d coins\n", tally.Voters, tally.Yes+tally.No, tally.Supply)
+		fmt.Printf("Status:   %s\n", status)
+	}
+	fmt.Printf("--------\n")
+}
+
+func (cli *CommandLine) recordFixture(file, chainID, nodeID string) {
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	blockchain.Handle(err)
+
+	fixture, err := chain.RecordFixture(wallets.GetAllAddresses())
+	blockchain.Handle(err)
+
+	err = fixture.Save(file)
+	blockchain.Handle(err)
+
+	fmt.Printf("recorded %d blocks to %s\n", len(fixture.Steps)+1, file)
+}
+
+func (cli *CommandLine) replay(file string) {
+	fixture, err := blockchain.LoadFixture(file)
+	blockchain.Handle(err)
+
+	result, err := blockchain.Replay(fixture)
+	if err != nil {
+		fmt.Println("replay failed:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Tip:             %s\n", result.State.TipHash)
+	fmt.Printf("Height:          %d\n", result.State.Height)
+	fmt.Printf("UTXO commitment: %s\n", result.State.UTXOCommitment)
+
+	if !result.OK() {
+		for _, mismatch := range result.Mismatches {
+			fmt.Println("mismatch:", mismatch)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Println("replay matches the fixture")
+}
+
+func (cli *CommandLine) simulate(config blockchain.SimulationConfig) {
+	fmt.Printf("Simulating %d wallets at %g transactions per second, a block every %s\n", config.Wallets, config.TPS, config.BlockInterval)
+
+	report, err := blockchain.Simulate(config, func(block *blockchain.Block, pooled int) {
+		fmt.Printf("block %d: %d transactions, %d left in the pool\n", block.Height, len(block.Transactions)-1, pooled)
+	})
+	if err != nil {
+		fmt.Println("simulation failed:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Transactions:  %d submitted, %d confirmed, %d rejected, %d skipped for lack of coins\n", report.Submitted, report.Confirmed, report.Rejected, report.Skipped)
+	fmt.Printf("Throughput:    %.2f confirmed transactions per second over %s\n", report.Throughput(), report.Elapsed.Round(time.Millisecond))
+	for _, latency := range []struct {
+		name  string
+		stats blockchain.LatencyStats
+	}{{"Validation", report.Validation}, {"Assembly", report.Assembly}, {"Connect", report.Connect}} {
+		fmt.Printf("%-14s mean %s, p50 %s, p99 %s, max %s over %d\n", latency.name+":", latency.stats.Mean, latency.stats.P50, latency.stats.P99, latency.stats.Max, latency.stats.Count)
+	}
+	fmt.Printf("Database:      %d bytes after genesis, %d bytes at the end, %+d bytes\n", report.DBStart, report.DBEnd, report.DBEnd-report.DBStart)
+}
+
+// parse metadata written as KEY=VALUE pairs joined by "&"
+func parseMetadata(meta string) map[string]string {
+	metadata := make(map[string]string)
+
+	for _, pair := range strings.Split(meta, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		metadata[key] = value
+	}
+
+	return metadata
+}
+
+func (cli *CommandLine) searchTransactions(query, chainID, nodeID string) {
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
+
+	matches, err := chain.SearchTransactions(query)
+	blockchain.Handle(err)
+
+	for _, match := range matches {
+		fmt.Printf("--------\n")
+		fmt.Printf("Transaction: %x\n", match.TxID)
+		fmt.Printf("Height:      %d\n", match.Height)
+		for key, value := range match.Metadata {
+			fmt.Printf("  %s = %s\n", key, value)
+		}
+	}
+	fmt.Printf("--------\n")
+	fmt.Printf("%d transactions found\n", len(matches))
 }
 
 func (cli *CommandLine) printChain(nodeID string) {
 	chain := blockchain.ContinueBlockChain(nodeID)
-	defer chain.Database.Close()
+	defer chain.Close()
 	iter := chain.Iterator()
 
 	for {
@@ -109,7 +454,7 @@ func (cli *CommandLine) printChain(nodeID string) {
 		fmt.Printf("Previous Hash: %x\n", block.PrevHash)
 		fmt.Printf("Current Hash: %x\n", block.Hash)
 
-		pow := blockchain.NewProof(block)
+		pow := blockchain.NewProof(block, chain.Params.Difficulty)
 		fmt.Printf("Proof-of-work: %s\n", strconv.FormatBool(pow.Validate()))
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
@@ -140,9 +485,58 @@ func (cli *CommandLine) listAddresses(nodeID string) {
 	}
 }
 
+// list the unspent outputs of an address, or of every wallet address when it is empty
+func (cli *CommandLine) listUnspent(address, nodeID string) {
+	wallets, err := wallet.CreateWallets(nodeID)
+	blockchain.Handle(err)
+
+	addresses := wallets.GetAllAddresses()
+	if address != "" {
+		if !wallet.ValidateAddress(address) {
+			log.Panic("Address is invalid")
+		}
+		addresses = []string{address}
+	}
+	slices.Sort(addresses)
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain, Frozen: wallets.Frozen}
+	defer chain.Close()
+
+	for _, address := range addresses {
+		publicKeyHash := wallet.Base58Decode([]byte(address))
+		publicKeyHash = publicKeyHash[1 : len(publicKeyHash)-4]
+
+		for _, coin := range UTXOSet.FindCoins(publicKeyHash) {
+			fmt.Printf("%s %s\n", address, coin)
+		}
+	}
+}
+
+// freeze or unfreeze coins of the node's wallets
+func (cli *CommandLine) freezeCoins(coins string, frozen bool, nodeID string) {
+	wallets, err := wallet.CreateWallets(nodeID)
+	blockchain.Handle(err)
+
+	for _, coin := range strings.Split(coins, ",") {
+		outpoint, err := blockchain.ParseOutpoint(coin)
+		blockchain.Handle(err)
+
+		if frozen {
+			wallets.Freeze(outpoint)
+			fmt.Printf("Froze %s\n", outpoint)
+		} else {
+			wallets.Unfreeze(outpoint)
+			fmt.Printf("Unfroze %s\n", outpoint)
+		}
+	}
+
+	wallets.SaveFile(nodeID)
+}
+
 func (cli *CommandLine) reindexUTXO(nodeID string) {
 	chain := blockchain.ContinueBlockChain(nodeID)
-	defer chain.Database.Close()
+	defer chain.Close()
 
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	UTXOSet.Reindex()
@@ -151,9 +545,356 @@ func (cli *CommandLine) reindexUTXO(nodeID string) {
 	fmt.Printf("Done! There are now %d transactions in the UTXO set.\n", count)
 }
 
-func (cli *CommandLine) StartNode(nodeID, minerAddress string) {
+func (cli *CommandLine) chainStats(chainID, nodeID string) {
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
+
+	stats := chain.GetStats()
+
+	fmt.Printf("--------\n")
+	fmt.Printf("Tip:          %x\n", stats.TipHash)
+	fmt.Printf("Height:       %d\n", stats.Height)
+	fmt.Printf("Transactions: %d\n", stats.Transactions)
+	fmt.Printf("UTXOs:        %d\n", stats.UTXOs)
+	fmt.Printf("Supply:       %d\n", stats.Supply)
+	fmt.Printf("--------\n")
+}
+
+func (cli *CommandLine) chainParams(chainID, nodeID string) {
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
+
+	data, err := json.MarshalIndent(chain.Params, "", "  ")
+	blockchain.Handle(err)
+
+	fmt.Println(string(data))
+}
+
+func (cli *CommandLine) backup(name, nodeID, online string) {
+	// a running node holds the database lock, so it has to take the snapshot itself
+	if online != "" {
+		data, err := network.CallRPC(online, "backup", []any{name})
+		blockchain.Handle(err)
+
+		var result network.BackupResult
+		err = json.Unmarshal(data, &result)
+		blockchain.Handle(err)
+
+		fmt.Printf("Chain database backed up by the running node to %s at height %d\n", result.Path, result.Height)
+		return
+	}
+
+	path, err := blockchain.BackupPath(name, nodeID)
+	blockchain.Handle(err)
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Close()
+
+	err = chain.BackupToFile(path)
+	blockchain.Handle(err)
+
+	fmt.Printf("Chain database backed up to %s\n", path)
+}
+
+func (cli *CommandLine) restore(file, nodeID string) {
+	f, err := os.Open(file)
+	blockchain.Handle(err)
+	defer f.Close()
+
+	chain, err := blockchain.RestoreBlockChain(nodeID, f)
+	if err != nil {
+		fmt.Println("restore failed:", err)
+		os.Exit(1)
+	}
+	defer chain.Close()
+
+	fmt.Printf("Chain database restored up to block %d\n", chain.GetBestHeight())
+}
+
+func (cli *CommandLine) exportWallets(file, nodeID string) {
+	wallets, err := wallet.CreateWallets(nodeID)
+	blockchain.Handle(err)
+
+	err = wallets.Export(file)
+	blockchain.Handle(err)
+
+	fmt.Printf("Exported %d wallets to %s\n", len(wallets.Wallets), file)
+}
+
+func (cli *CommandLine) importWallets(file, nodeID string) {
+	wallets, _ := wallet.CreateWallets(nodeID)
+
+	imported, err := wallets.Import(file)
+	blockchain.Handle(err)
+	wallets.SaveFile(nodeID)
+
+	fmt.Printf("Imported %d wallets from %s\n", imported, file)
+}
+
+func (cli *CommandLine) bridge(from, to string, amount int, destChain, chainID, nodeID string, burn, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is invalid")
+	}
+
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	UTXOSet.Frozen = wallets.Frozen
+	wallet := wallets.GetWallet(from)
+
+	kind := blockchain.BridgeLock
+	if burn {
+		kind = blockchain.BridgeBurn
+	}
+
+	tx, err := blockchain.NewBridgeTransfer(&wallet, kind, destChain, to, amount, &UTXOSet)
+	blockchain.Handle(err)
+
+	if mineNow {
+		cbTx := chain.NewCoinbaseTx(from, "", 0)
+		txs := []*blockchain.Transaction{cbTx, tx}
+		chain.MineBlock(txs)
+	} else {
+		err := network.BroadcastTransaction(nodeID, chain, tx)
+		blockchain.Handle(err)
+		fmt.Println("Sent transaction")
+	}
+
+	fmt.Printf("Bridge transfer %x moves %d tokens to %s on chain %s\n", tx.ID, amount, to, destChain)
+}
+
+// the hash to anchor or check, either given directly or computed from a file
+func anchorHash(file, hash string) []byte {
+	if file != "" {
+		sum, err := blockchain.HashFile(file)
+		blockchain.Handle(err)
+		return sum
+	}
+
+	sum, err := hex.DecodeString(hash)
+	blockchain.Handle(err)
+	return sum
+}
+
+func (cli *CommandLine) anchor(hash []byte, from, nodeID string, mineNow bool) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address is invalid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Close()
+
+	wallets, err := wallet.CreateWallets(nodeID)
+	blockchain.Handle(err)
+	UTXOSet.Frozen = wallets.Frozen
+	wallet := wallets.GetWallet(from)
+
+	tx, err := blockchain.NewAnchorTransaction(&wallet, hash, &UTXOSet)
+	blockchain.Handle(err)
+
+	if mineNow {
+		cbTx := chain.NewCoinbaseTx(from, "", blockchain.AnchorFee)
+		txs := []*blockchain.Transaction{cbTx, tx}
+		chain.MineBlock(txs)
+	} else {
+		err := network.BroadcastTransaction(nodeID, chain, tx)
+		blockchain.Handle(err)
+		fmt.Println("Sent transaction")
+	}
+
+	fmt.Printf("Anchored %x in transaction %x\n", hash, tx.ID)
+}
+
+func (cli *CommandLine) anchorProof(txID, file, nodeID string) {
+	chain := blockchain.ContinueBlockChain(nodeID)
+	defer chain.Close()
+
+	id, err := hex.DecodeString(txID)
+	blockchain.Handle(err)
+
+	proof, err := chain.NewAnchorProof(id)
+	blockchain.Handle(err)
+
+	err = os.WriteFile(file, proof.Serialize(), 0644)
+	blockchain.Handle(err)
+
+	fmt.Printf("Anchor proof with %d headers written to %s\n", len(proof.Headers), file)
+}
+
+// check a proof, against the node's chain too when the node has one
+func (cli *CommandLine) verifyAnchor(file string, hash []byte, nodeID string) {
+	data, err := os.ReadFile(file)
+	blockchain.Handle(err)
+
+	proof, err := blockchain.DeserializeAnchorProof(data)
+	blockchain.Handle(err)
+
+	claimedAt, err := proof.Verify(hash)
+	if err == nil {
+		// the chain ID names the local database the proof is checked against
+		err = blockchain.ValidateChainID(proof.ChainID)
+	}
+	if err != nil {
+		fmt.Println("Proof is invalid:", err)
+		os.Exit(1)
+	}
+
+	if !blockchain.DBexists(blockchain.ChainPath(proof.ChainID, nodeID)) {
+		fmt.Printf("%x is anchored in block %x at height %d, buried under %d blocks\n",
+			hash, proof.Headers[0].Hash, proof.Headers[0].Height, len(proof.Headers)-1)
+		fmt.Printf("No local copy of the chain, neither the block nor its time %s could be checked against it\n",
+			claimedAt.UTC().Format(time.RFC3339))
+		return
+	}
+
+	chain := blockchain.ContinueChain(proof.ChainID, nodeID)
+	defer chain.Close()
+
+	anchoredAt, err := chain.CheckAnchorProof(proof, hash)
+	if err != nil {
+		fmt.Println("Proof does not match the local chain:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%x existed before %s, block %x at height %d, buried under %d blocks\n",
+		hash, anchoredAt.UTC().Format(time.RFC3339), proof.Headers[0].Hash, proof.Headers[0].Height, len(proof.Headers)-1)
+	fmt.Println("The block is part of the local main chain")
+}
+
+func (cli *CommandLine) bridgeProof(txID, file, chainID, nodeID string) {
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
+
+	id, err := hex.DecodeString(txID)
+	blockchain.Handle(err)
+
+	proof, err := chain.NewBridgeProof(id)
+	blockchain.Handle(err)
+
+	err = os.WriteFile(file, proof.Serialize(), 0644)
+	blockchain.Handle(err)
+
+	fmt.Printf("Bridge proof written to %s\n", file)
+}
+
+func (cli *CommandLine) bridgeClaim(file, chainID, nodeID, minerAddress string) {
+	data, err := os.ReadFile(file)
+	blockchain.Handle(err)
+
+	proof, err := blockchain.DeserializeBridgeProof(data)
+	blockchain.Handle(err)
+
+	tx, err := blockchain.NewBridgeClaim(proof)
+	blockchain.Handle(err)
+
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
+
+	if err := chain.CheckBridgeClaim(tx, nil); err != nil {
+		log.Panicf("Bridge claim is not valid on this chain: %v", err)
+	}
+	if !chain.VerifyTransaction(tx) {
+		log.Panic("Bridge claim is not valid on this chain")
+	}
+
+	if minerAddress != "" {
+		if !wallet.ValidateAddress(minerAddress) {
+			log.Panic("Wrong miner address!")
+		}
+		cbTx := chain.NewCoinbaseTx(minerAddress, "", 0)
+		txs := []*blockchain.Transaction{cbTx, tx}
+		chain.MineBlock(txs)
+	} else {
+		err := network.BroadcastTransaction(nodeID, chain, tx)
+		blockchain.Handle(err)
+		fmt.Println("Sent transaction")
+	}
+
+	fmt.Printf("Claimed %d tokens from chain %s\n", tx.Outputs[0].Value, proof.SourceChain)
+}
+
+func (cli *CommandLine) bridgeTrack(sourceChainID, chainID, nodeID string) {
+	source := blockchain.ContinueChain(sourceChainID, nodeID)
+	defer source.Close()
+
+	chain := blockchain.ContinueChain(chainID, nodeID)
+	defer chain.Close()
+
+	copied, err := chain.TrackSourceChain(source)
+	blockchain.Handle(err)
+
+	fmt.Printf("Tracked %d new headers of chain %s, up to height %d\n", copied, sourceChainID, source.GetBestHeight())
+}
+
+func (cli *CommandLine) subscribe(address, topics string) {
+	var subscriptions []string
+	if topics != "" {
+		subscriptions = strings.Split(topics, ",")
+	}
+
+	err := network.Subscribe(address, subscriptions, func(event network.Event) {
+		switch event.Topic {
+		case network.TopicRawBlock:
+			block := blockchain.Deserialize(event.Body)
+			fmt.Printf("%s #%d: block %x at height %d with %d transactions\n", event.Topic, event.Sequence, block.Hash, block.Height, len(block.Transactions))
+		case network.TopicRawTx:
+			tx := blockchain.DeserializeTransaction(event.Body)
+			fmt.Printf("%s #%d: transaction %x with %d inputs and %d outputs\n", event.Topic, event.Sequence, tx.ID, len(tx.Inputs), len(tx.Outputs))
+		default:
+			fmt.Printf("%s #%d: %x\n", event.Topic, event.Sequence, event.Body)
+		}
+	})
+	blockchain.Handle(err)
+
+	fmt.Println("Publisher closed the connection")
+}
+
+func (cli *CommandLine) rpc(address, method, params string) {
+	var values []any
+	if params != "" {
+		err := json.Unmarshal([]byte(params), &values)
+		blockchain.Handle(err)
+	}
+
+	result, err := network.CallRPC(address, method, values)
+	blockchain.Handle(err)
+
+	var out bytes.Buffer
+	err = json.Indent(&out, result, "", "  ")
+	blockchain.Handle(err)
+	fmt.Println(out.String())
+}
+
+func (cli *CommandLine) StartNode(nodeID, minerAddress, advertise, publish, rpcAddress, config string) {
 	fmt.Printf("Starting Node %s\n", nodeID)
 
+	if config != "" {
+		if err := network.SetConfigFile(config); err != nil {
+			log.Panic(err)
+		}
+		fmt.Println("Reading the node's policy from", config)
+	}
+
+	if advertise != "" {
+		fmt.Println("Advertising the node as", advertise)
+		network.SetAdvertisedAddress(advertise)
+	}
+	if publish != "" {
+		network.SetPublisherAddress(publish)
+	}
+	if rpcAddress != "" {
+		network.SetRPCAddress(rpcAddress)
+	}
+	if proxy := network.Proxy(); proxy != "" {
+		fmt.Println("Outbound connections go through the SOCKS5 proxy at", proxy)
+	}
+
 	if len(minerAddress) > 0 {
 		if wallet.ValidateAddress(minerAddress) {
 			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
@@ -180,6 +921,14 @@ func (cli *CommandLine) Run() {
 		runtime.Goexit()
 	}
 
+	if err := network.SetProxy(os.Getenv("NODE_PROXY")); err != nil {
+		fmt.Println("NODE_PROXY is invalid:", err)
+		runtime.Goexit()
+	}
+	if peers := os.Getenv("NODE_PEERS"); peers != "" {
+		network.KnownNodes = strings.Split(peers, ",")
+	}
+
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockChainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
@@ -187,15 +936,122 @@ func (cli *CommandLine) Run() {
 	createwalletcmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listaddressescmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reeindexUTXOcmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	freezeCmd := flag.NewFlagSet("freeze", flag.ExitOnError)
+	unfreezeCmd := flag.NewFlagSet("unfreeze", flag.ExitOnError)
+	chainStatsCmd := flag.NewFlagSet("chainstats", flag.ExitOnError)
+	chainParamsCmd := flag.NewFlagSet("chainparams", flag.ExitOnError)
+	searchTxCmd := flag.NewFlagSet("searchtx", flag.ExitOnError)
+	recordFixtureCmd := flag.NewFlagSet("recordfixture", flag.ExitOnError)
+	proposeCmd := flag.NewFlagSet("propose", flag.ExitOnError)
+	voteCmd := flag.NewFlagSet("vote", flag.ExitOnError)
+	tallyCmd := flag.NewFlagSet("tally", flag.ExitOnError)
+	registerNameCmd := flag.NewFlagSet("registername", flag.ExitOnError)
+	anchorCmd := flag.NewFlagSet("anchor", flag.ExitOnError)
+	anchorProofCmd := flag.NewFlagSet("anchorproof", flag.ExitOnError)
+	verifyAnchorCmd := flag.NewFlagSet("verifyanchor", flag.ExitOnError)
+	lookupNameCmd := flag.NewFlagSet("lookupname", flag.ExitOnError)
+	replayCmd := flag.NewFlagSet("replay", flag.ExitOnError)
+	simulateCmd := flag.NewFlagSet("simulate", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	subscribeCmd := flag.NewFlagSet("subscribe", flag.ExitOnError)
+	rpcCmd := flag.NewFlagSet("rpc", flag.ExitOnError)
+	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	exportWalletsCmd := flag.NewFlagSet("exportwallets", flag.ExitOnError)
+	importWalletsCmd := flag.NewFlagSet("importwallets", flag.ExitOnError)
+	bridgeCmd := flag.NewFlagSet("bridge", flag.ExitOnError)
+	bridgeProofCmd := flag.NewFlagSet("bridgeproof", flag.ExitOnError)
+	bridgeClaimCmd := flag.NewFlagSet("bridgeclaim", flag.ExitOnError)
+	bridgeTrackCmd := flag.NewFlagSet("bridgetrack", flag.ExitOnError)
 
 	getBalanceAddresss := getBalanceCmd.String("address", "", "The address of the account you want to check the balance on")
+	getBalanceChain := getBalanceCmd.String("chain", blockchain.MainChainID, "The chain to check the balance on")
 	createBlockChainAddress := createBlockChainCmd.String("address", "", "The address of the account who will mine the genesis block")
+	createBlockChainChain := createBlockChainCmd.String("chain", blockchain.MainChainID, "The ID of the chain to create")
+	createBlockChainParams := createBlockChainCmd.String("params", "", "The JSON file holding the parameters of the network to create")
 	sendFrom := sendCmd.String("from", "", "The address of the account you want to send tokens from")
 	sendTo := sendCmd.String("to", "", "The address of the account you want to send tokens to")
 	sendAmount := sendCmd.Int("amount", 0, "The amount of tokens you want to send")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendFee := sendCmd.Int("fee", 0, "Fee left to the miner including the transaction")
+	sendMeta := sendCmd.String("meta", "", "Metadata to attach to the transaction, as KEY=VALUE pairs joined by &")
+	sendCoins := sendCmd.String("coins", "", "The coins to spend, as TXID:INDEX pairs separated by commas")
+	sendChange := sendCmd.String("change", "", "The address receiving the change of the spent coins")
+	listUnspentAddress := listUnspentCmd.String("address", "", "The address to list the coins of")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "The address to list the transactions of")
+	listTransactionsChain := listTransactionsCmd.String("chain", blockchain.MainChainID, "The chain to list the transactions on")
+	freezeCoins := freezeCmd.String("coins", "", "The coins to freeze, as TXID:INDEX pairs separated by commas")
+	unfreezeCoins := unfreezeCmd.String("coins", "", "The coins to unfreeze, as TXID:INDEX pairs separated by commas")
+	anchorFile := anchorCmd.String("file", "", "The file whose digest is anchored")
+	anchorHashHex := anchorCmd.String("hash", "", "The hex encoded SHA-256 hash to anchor")
+	anchorFrom := anchorCmd.String("from", "", "The address paying for the anchor")
+	anchorMine := anchorCmd.Bool("mine", false, "Mine immediately on the same node")
+	anchorProofTx := anchorProofCmd.String("tx", "", "The ID of the anchor transaction")
+	anchorProofOut := anchorProofCmd.String("out", "", "The file the proof is written to")
+	verifyAnchorIn := verifyAnchorCmd.String("in", "", "The proof to check")
+	verifyAnchorFile := verifyAnchorCmd.String("file", "", "The file the proof should cover")
+	verifyAnchorHash := verifyAnchorCmd.String("hash", "", "The hex encoded hash the proof should cover")
+	registerNameName := registerNameCmd.String("name", "", "The name to register")
+	registerNameFrom := registerNameCmd.String("from", "", "The address the name points to")
+	registerNameRenew := registerNameCmd.Bool("renew", false, "Renew a name the address already owns")
+	registerNameMine := registerNameCmd.Bool("mine", false, "Mine immediately on the same node")
+	lookupNameName := lookupNameCmd.String("name", "", "The name to look up")
+	proposeFrom := proposeCmd.String("from", "", "The address making the proposal")
+	proposeParam := proposeCmd.String("param", "", "The parameter to change")
+	proposeValue := proposeCmd.Int("value", -1, "The new value of the parameter")
+	proposeTitle := proposeCmd.String("title", "", "A short description of the proposal")
+	proposeMine := proposeCmd.Bool("mine", false, "Mine immediately on the same node")
+	voteFrom := voteCmd.String("from", "", "The address voting")
+	voteProposal := voteCmd.String("proposal", "", "The ID of the proposal")
+	voteNo := voteCmd.Bool("no", false, "Vote against the proposal")
+	voteMine := voteCmd.Bool("mine", false, "Mine immediately on the same node")
+	tallyProposal := tallyCmd.String("proposal", "", "The ID of the proposal")
+	recordFixtureOut := recordFixtureCmd.String("out", "", "The file to write the fixture to")
+	recordFixtureChain := recordFixtureCmd.String("chain", blockchain.MainChainID, "The chain to record")
+	replayIn := replayCmd.String("in", "", "The fixture to replay")
+	simulateWallets := simulateCmd.Int("wallets", blockchain.DefaultSimulationConfig.Wallets, "The number of synthetic wallets")
+	simulateTPS := simulateCmd.Float64("tps", blockchain.DefaultSimulationConfig.TPS, "The targeted rate of transactions per second")
+	simulateInterval := simulateCmd.Duration("interval", blockchain.DefaultSimulationConfig.BlockInterval, "The time between two blocks")
+	simulateBlocks := simulateCmd.Int("blocks", blockchain.DefaultSimulationConfig.Blocks, "The number of blocks to mine")
+	simulateFee := simulateCmd.Int("fee", blockchain.DefaultSimulationConfig.Fee, "The fee every transaction pays")
+	simulateDifficulty := simulateCmd.Int("difficulty", blockchain.DefaultSimulationConfig.Difficulty, "The proof-of-work of the regtest chain")
+	searchTxQuery := searchTxCmd.String("query", "", "The metadata to look for")
+	searchTxChain := searchTxCmd.String("chain", blockchain.MainChainID, "The chain to search")
+	chainStatsChain := chainStatsCmd.String("chain", blockchain.MainChainID, "The chain to print the totals of")
+	chainParamsChain := chainParamsCmd.String("chain", blockchain.MainChainID, "The chain to print the parameters of")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeAdvertise := startNodeCmd.String("advertise", "", "The address peers reach the node at, such as its onion service")
+	startNodePublish := startNodeCmd.String("publish", "", "The address to publish accepted blocks and transactions on")
+	startNodeRPC := startNodeCmd.String("rpc", "", "The address to serve JSON-RPC on")
+	startNodeConfig := startNodeCmd.String("config", "", "The JSON file holding the node's relay policy and log level")
+	startNodeCheckChain := startNodeCmd.Bool("checkchain", false, "Check every block of the chain before starting, not only after an unclean shutdown")
+	rpcAddress := rpcCmd.String("address", "", "The JSON-RPC address of the node")
+	rpcMethod := rpcCmd.String("method", "", "The method to call")
+	rpcParams := rpcCmd.String("params", "", "The params of the call, as a JSON array")
+	subscribeAddress := subscribeCmd.String("address", "", "The publisher address of the node")
+	subscribeTopic := subscribeCmd.String("topic", "", "The topics to subscribe to, separated by commas")
+	backupOut := backupCmd.String("out", "", "The name of the backup file in the node's backup directory")
+	backupOnline := backupCmd.String("online", "", "The JSON-RPC address of the running node to take the backup")
+	restoreIn := restoreCmd.String("in", "", "The backup file to restore from")
+	exportWalletsOut := exportWalletsCmd.String("out", "", "The file the wallet bundle is written to")
+	importWalletsIn := importWalletsCmd.String("in", "", "The wallet bundle file to import")
+	bridgeFrom := bridgeCmd.String("from", "", "The address of the account you want to move tokens from")
+	bridgeTo := bridgeCmd.String("to", "", "The address that receives the tokens on the destination chain")
+	bridgeAmount := bridgeCmd.Int("amount", 0, "The amount of tokens you want to move")
+	bridgeDest := bridgeCmd.String("dest", "", "The ID of the destination chain")
+	bridgeChain := bridgeCmd.String("chain", blockchain.MainChainID, "The chain the tokens are moved from")
+	bridgeBurn := bridgeCmd.Bool("burn", false, "Burn mirrored tokens to unlock them on their origin chain")
+	bridgeMine := bridgeCmd.Bool("mine", false, "Mine immediately on the same node")
+	bridgeProofTx := bridgeProofCmd.String("tx", "", "The ID of the bridge transfer transaction")
+	bridgeProofOut := bridgeProofCmd.String("out", "", "The file the proof is written to")
+	bridgeProofChain := bridgeProofCmd.String("chain", blockchain.MainChainID, "The chain holding the transfer")
+	bridgeClaimIn := bridgeClaimCmd.String("in", "", "The bridge proof file to claim")
+	bridgeClaimChain := bridgeClaimCmd.String("chain", blockchain.MainChainID, "The chain the tokens are claimed on")
+	bridgeClaimMiner := bridgeClaimCmd.String("miner", "", "Mine the claim immediately and send the reward to ADDRESS")
+	bridgeTrackSource := bridgeTrackCmd.String("source", "", "The chain whose headers are tracked")
+	bridgeTrackChain := bridgeTrackCmd.String("chain", blockchain.MainChainID, "The chain tracking them")
 
 	switch os.Args[1] {
 	case "getbalance":
@@ -219,9 +1075,93 @@ func (cli *CommandLine) Run() {
 	case "reindexutxo":
 		err := reeindexUTXOcmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
+	case "listunspent":
+		err := listUnspentCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "freeze":
+		err := freezeCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "unfreeze":
+		err := unfreezeCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "chainstats":
+		err := chainStatsCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "chainparams":
+		err := chainParamsCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "anchor":
+		err := anchorCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "anchorproof":
+		err := anchorProofCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "verifyanchor":
+		err := verifyAnchorCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "registername":
+		err := registerNameCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "lookupname":
+		err := lookupNameCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "propose":
+		err := proposeCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "vote":
+		err := voteCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "tally":
+		err := tallyCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "recordfixture":
+		err := recordFixtureCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "replay":
+		err := replayCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "simulate":
+		err := simulateCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "searchtx":
+		err := searchTxCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
 	case "startnode":
 		err := startNodeCmd.Parse(os.Args[2:])
 		blockchain.Handle(err)
+	case "subscribe":
+		err := subscribeCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "rpc":
+		err := rpcCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "backup":
+		err := backupCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "restore":
+		err := restoreCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "exportwallets":
+		err := exportWalletsCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "importwallets":
+		err := importWalletsCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "bridge":
+		err := bridgeCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "bridgeproof":
+		err := bridgeProofCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "bridgeclaim":
+		err := bridgeClaimCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
+	case "bridgetrack":
+		err := bridgeTrackCmd.Parse(os.Args[2:])
+		blockchain.Handle(err)
 	default:
 		cli.printUsage()
 		runtime.Goexit()
@@ -232,7 +1172,7 @@ func (cli *CommandLine) Run() {
 			getBalanceCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.getBalance(*getBalanceAddresss, nodeID)
+		cli.getBalance(*getBalanceAddresss, *getBalanceChain, nodeID)
 	}
 
 	if createBlockChainCmd.Parsed() {
@@ -240,15 +1180,15 @@ func (cli *CommandLine) Run() {
 			createBlockChainCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.createBlockChain(*createBlockChainAddress, nodeID)
+		cli.createBlockChain(*createBlockChainAddress, *createBlockChainChain, *createBlockChainParams, nodeID)
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount == 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount == 0 || *sendFee < 0 {
 			sendCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendMeta, *sendCoins, *sendChange, nodeID, *sendMine)
 	}
 
 	if printChainCmd.Parsed() {
@@ -267,12 +1207,224 @@ func (cli *CommandLine) Run() {
 		cli.reindexUTXO(nodeID)
 	}
 
+	if listUnspentCmd.Parsed() {
+		cli.listUnspent(*listUnspentAddress, nodeID)
+	}
+
+	if listTransactionsCmd.Parsed() {
+		if *listTransactionsAddress == "" {
+			listTransactionsCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.listTransactions(*listTransactionsAddress, *listTransactionsChain, nodeID)
+	}
+
+	if freezeCmd.Parsed() {
+		if *freezeCoins == "" {
+			freezeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.freezeCoins(*freezeCoins, true, nodeID)
+	}
+
+	if unfreezeCmd.Parsed() {
+		if *unfreezeCoins == "" {
+			unfreezeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.freezeCoins(*unfreezeCoins, false, nodeID)
+	}
+
+	if chainStatsCmd.Parsed() {
+		cli.chainStats(*chainStatsChain, nodeID)
+	}
+
+	if chainParamsCmd.Parsed() {
+		cli.chainParams(*chainParamsChain, nodeID)
+	}
+
+	if anchorCmd.Parsed() {
+		if (*anchorFile == "") == (*anchorHashHex == "") || *anchorFrom == "" {
+			anchorCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.anchor(anchorHash(*anchorFile, *anchorHashHex), *anchorFrom, nodeID, *anchorMine)
+	}
+
+	if anchorProofCmd.Parsed() {
+		if *anchorProofTx == "" || *anchorProofOut == "" {
+			anchorProofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.anchorProof(*anchorProofTx, *anchorProofOut, nodeID)
+	}
+
+	if verifyAnchorCmd.Parsed() {
+		if *verifyAnchorIn == "" || (*verifyAnchorFile == "") == (*verifyAnchorHash == "") {
+			verifyAnchorCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.verifyAnchor(*verifyAnchorIn, anchorHash(*verifyAnchorFile, *verifyAnchorHash), nodeID)
+	}
+
+	if registerNameCmd.Parsed() {
+		if *registerNameName == "" || *registerNameFrom == "" {
+			registerNameCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.registerName(*registerNameName, *registerNameFrom, *registerNameRenew, nodeID, *registerNameMine)
+	}
+
+	if lookupNameCmd.Parsed() {
+		if *lookupNameName == "" {
+			lookupNameCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.lookupName(*lookupNameName, nodeID)
+	}
+
+	if proposeCmd.Parsed() {
+		if *proposeFrom == "" || *proposeParam == "" || *proposeValue < 0 {
+			proposeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.propose(*proposeFrom, *proposeTitle, *proposeParam, *proposeValue, nodeID, *proposeMine)
+	}
+
+	if voteCmd.Parsed() {
+		if *voteFrom == "" || *voteProposal == "" {
+			voteCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.vote(*voteFrom, *voteProposal, !*voteNo, nodeID, *voteMine)
+	}
+
+	if tallyCmd.Parsed() {
+		cli.tally(*tallyProposal, nodeID)
+	}
+
+	if recordFixtureCmd.Parsed() {
+		if *recordFixtureOut == "" {
+			recordFixtureCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.recordFixture(*recordFixtureOut, *recordFixtureChain, nodeID)
+	}
+
+	if replayCmd.Parsed() {
+		if *replayIn == "" {
+			replayCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.replay(*replayIn)
+	}
+
+	if simulateCmd.Parsed() {
+		cli.simulate(blockchain.SimulationConfig{
+			Wallets:       *simulateWallets,
+			TPS:           *simulateTPS,
+			BlockInterval: *simulateInterval,
+			Blocks:        *simulateBlocks,
+			Fee:           *simulateFee,
+			Difficulty:    *simulateDifficulty,
+		})
+	}
+
+	if searchTxCmd.Parsed() {
+		if *searchTxQuery == "" {
+			searchTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.searchTransactions(*searchTxQuery, *searchTxChain, nodeID)
+	}
+
 	if startNodeCmd.Parsed() {
 		nodeID := os.Getenv("NODE_ID")
 		if nodeID == "" {
 			startNodeCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.StartNode(nodeID, *startNodeMiner)
+		blockchain.FullCheckOnOpen = *startNodeCheckChain
+		cli.StartNode(nodeID, *startNodeMiner, *startNodeAdvertise, *startNodePublish, *startNodeRPC, *startNodeConfig)
+	}
+
+	if rpcCmd.Parsed() {
+		if *rpcAddress == "" || *rpcMethod == "" {
+			rpcCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.rpc(*rpcAddress, *rpcMethod, *rpcParams)
+	}
+
+	if subscribeCmd.Parsed() {
+		if *subscribeAddress == "" {
+			subscribeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.subscribe(*subscribeAddress, *subscribeTopic)
+	}
+
+	if backupCmd.Parsed() {
+		if *backupOut == "" {
+			backupCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.backup(*backupOut, nodeID, *backupOnline)
+	}
+
+	if restoreCmd.Parsed() {
+		if *restoreIn == "" {
+			restoreCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.restore(*restoreIn, nodeID)
+	}
+
+	if exportWalletsCmd.Parsed() {
+		if *exportWalletsOut == "" {
+			exportWalletsCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exportWallets(*exportWalletsOut, nodeID)
+	}
+
+	if importWalletsCmd.Parsed() {
+		if *importWalletsIn == "" {
+			importWalletsCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importWallets(*importWalletsIn, nodeID)
+	}
+
+	if bridgeCmd.Parsed() {
+		if *bridgeFrom == "" || *bridgeTo == "" || *bridgeAmount == 0 || *bridgeDest == "" {
+			bridgeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.bridge(*bridgeFrom, *bridgeTo, *bridgeAmount, *bridgeDest, *bridgeChain, nodeID, *bridgeBurn, *bridgeMine)
+	}
+
+	if bridgeProofCmd.Parsed() {
+		if *bridgeProofTx == "" || *bridgeProofOut == "" {
+			bridgeProofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.bridgeProof(*bridgeProofTx, *bridgeProofOut, *bridgeProofChain, nodeID)
+	}
+
+	if bridgeClaimCmd.Parsed() {
+		if *bridgeClaimIn == "" {
+			bridgeClaimCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.bridgeClaim(*bridgeClaimIn, *bridgeClaimChain, nodeID, *bridgeClaimMiner)
+	}
+
+	if bridgeTrackCmd.Parsed() {
+		if *bridgeTrackSource == "" {
+			bridgeTrackCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.bridgeTrack(*bridgeTrackSource, *bridgeTrackChain, nodeID)
 	}
 }
@@ -5,7 +5,9 @@ go 1.24
 require (
 	github.com/dgraph-io/badger v1.6.2
 	github.com/mr-tron/base58 v1.2.0
+	github.com/vrecan/death/v3 v3.0.3
 	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.35.0
 )
 
 require (
@@ -18,8 +20,6 @@ require (
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
-	github.com/vrecan/death/v3 v3.0.3 // indirect
-	golang.org/x/net v0.35.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
 	google.golang.org/protobuf v1.36.5 // indirect
 )
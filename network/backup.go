@@ -0,0 +1,35 @@
+package network
+
+import (
+	"encoding/json"
+	"golang-blockchain/blockchain"
+)
+
+// a running node holds the database lock, so a backup of it is taken by the node itself, on request of its
+// operator over the RPC interface, the backup is written to the node's backup directory under the given name
+type BackupResult struct {
+	Path   string `json:"path"`
+	Height int    `json:"height"`
+}
+
+func init() {
+	registerRPC("backup", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var name string
+		if err := parseParams(params, &name); err != nil {
+			return nil, err
+		}
+
+		path, err := blockchain.BackupPath(name, serverNodeID)
+		if err != nil {
+			return nil, &RPCError{RPCErrorInvalidParams, err.Error()}
+		}
+
+		height := chain.GetBestHeight()
+		if err := chain.BackupToFile(path); err != nil {
+			return nil, err
+		}
+		logf(LogInfo, "Chain database backed up to %s\n", path)
+
+		return BackupResult{path, height}, nil
+	})
+}
@@ -0,0 +1,111 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"golang-blockchain/blockchain"
+)
+
+// the wallet queries of the RPC interface, answered from the chain and the node's pool
+// confirmations follow the main chain, a transaction whose block is disconnected by a reorganization is listed
+// as unconfirmed again, or not at all until it returns to the pool
+type WalletTransactionView struct {
+	TxID          string `json:"txid"`
+	Status        string `json:"status"`
+	Height        int    `json:"height,omitempty"`
+	Confirmations int    `json:"confirmations"`
+	Coinbase      bool   `json:"coinbase,omitempty"`
+}
+
+// the summary of an address for explorers, the heights are -1 for an address no transaction has touched
+type AddressInfoView struct {
+	Address      string `json:"address"`
+	FirstSeen    int    `json:"firstSeen"`
+	LastActive   int    `json:"lastActive"`
+	Received     int    `json:"received"`
+	Sent         int    `json:"sent"`
+	Balance      int    `json:"balance"`
+	Transactions int    `json:"txCount"`
+}
+
+const (
+	TransactionConfirmed   = "confirmed"
+	TransactionUnconfirmed = "unconfirmed"
+	TransactionImmature    = "immature" // a coinbase transaction whose outputs cannot be spent yet
+)
+
+func NewWalletTransactionView(tx blockchain.WalletTransaction, params blockchain.Params) WalletTransactionView {
+	view := WalletTransactionView{TxID: hex.EncodeToString(tx.TxID), Confirmations: tx.Confirmations, Coinbase: tx.Coinbase}
+
+	switch {
+	case tx.Height < 0:
+		view.Status = TransactionUnconfirmed
+	case tx.Coinbase && tx.Confirmations < params.CoinbaseMaturity:
+		view.Status, view.Height = TransactionImmature, tx.Height
+	default:
+		view.Status, view.Height = TransactionConfirmed, tx.Height
+	}
+
+	return view
+}
+
+func init() {
+	registerRPC("getbalances", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var address string
+		if err := parseParams(params, &address); err != nil {
+			return nil, err
+		}
+
+		publicKeyHash, err := addressParam(address, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		poolMu.RLock()
+		defer poolMu.RUnlock()
+
+		return chain.WalletBalance(publicKeyHash, memoryPool), nil
+	})
+
+	registerRPC("listtransactions", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var address string
+		if err := parseParams(params, &address); err != nil {
+			return nil, err
+		}
+
+		publicKeyHash, err := addressParam(address, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		poolMu.RLock()
+		txs := chain.WalletTransactions(publicKeyHash, memoryPool)
+		poolMu.RUnlock()
+
+		views := []WalletTransactionView{}
+		for _, tx := range txs {
+			views = append(views, NewWalletTransactionView(tx, chain.Params))
+		}
+		return views, nil
+	})
+
+	registerRPC("getaddressinfo", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var address string
+		if err := parseParams(params, &address); err != nil {
+			return nil, err
+		}
+
+		publicKeyHash, err := addressParam(address, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		view := AddressInfoView{Address: address, FirstSeen: -1, LastActive: -1}
+		if info, ok := chain.AddressInfo(publicKeyHash); ok {
+			view.FirstSeen, view.LastActive = info.FirstSeen, info.LastActive
+			view.Received, view.Sent, view.Transactions = info.Received, info.Sent, info.Transactions
+			view.Balance = chain.GetBalance(publicKeyHash)
+		}
+		return view, nil
+	})
+}
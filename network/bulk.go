@@ -0,0 +1,115 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang-blockchain/blockchain"
+	"golang-blockchain/wallet"
+)
+
+// bulk queries answer for many blocks or addresses in one call, so backfilling an explorer or an exchange does
+// not take a round trip per item, an item that is not found is answered with null instead of failing the call
+const maxBulkItems = 1000
+
+type AddressUTXOs struct {
+	Address string     `json:"address"`
+	Balance int        `json:"balance"`
+	UTXOs   []UTXOView `json:"utxos"`
+}
+
+type UTXOView struct {
+	TxID          string `json:"txid"`
+	Output        int    `json:"vout"`
+	Value         int    `json:"value"`
+	Confirmations int    `json:"confirmations"`
+	Immature      bool   `json:"immature,omitempty"`
+}
+
+// the public key hash of an address given as the param at the given position
+func addressParam(address string, position int) ([]byte, error) {
+	if !wallet.ValidateAddress(address) {
+		return nil, &RPCError{RPCErrorInvalidParams, fmt.Sprintf("address %d is invalid", position)}
+	}
+
+	publicKeyHash := wallet.Base58Decode([]byte(address))
+	return publicKeyHash[1 : len(publicKeyHash)-4], nil
+}
+
+func checkBulkSize(items int, what string) error {
+	if items == 0 || items > maxBulkItems {
+		return &RPCError{RPCErrorInvalidParams, fmt.Sprintf("between 1 and %d %s are expected", maxBulkItems, what)}
+	}
+	return nil
+}
+
+func init() {
+	registerRPC("getblocks", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var hashes []string
+		var verbose bool
+		if err := parseParams(params, &hashes, &verbose); err != nil {
+			return nil, err
+		}
+		if err := checkBulkSize(len(hashes), "block hashes"); err != nil {
+			return nil, err
+		}
+
+		blocks := make([]*BlockView, len(hashes))
+		for i, hash := range hashes {
+			id, err := hex.DecodeString(hash)
+			if err != nil || len(id) == 0 {
+				return nil, &RPCError{RPCErrorInvalidParams, fmt.Sprintf("block hash %d is not hex encoded", i+1)}
+			}
+
+			block, err := chain.GetBlock(id)
+			if err != nil {
+				continue
+			}
+			view := NewBlockView(&block, chain.Params, verbose)
+			blocks[i] = &view
+		}
+
+		return blocks, nil
+	})
+
+	// the UTXO set is scanned once for every address of the call
+	registerRPC("getutxos", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var addresses []string
+		if err := parseParams(params, &addresses); err != nil {
+			return nil, err
+		}
+		if err := checkBulkSize(len(addresses), "addresses"); err != nil {
+			return nil, err
+		}
+
+		var publicKeyHashes [][]byte
+		seen := make(map[string]bool)
+		for i, address := range addresses {
+			publicKeyHash, err := addressParam(address, i+1)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[string(publicKeyHash)] {
+				seen[string(publicKeyHash)] = true
+				publicKeyHashes = append(publicKeyHashes, publicKeyHash)
+			}
+		}
+
+		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+		coins := UTXOSet.FindCoinsOf(publicKeyHashes)
+
+		results := make([]AddressUTXOs, len(addresses))
+		for i, address := range addresses {
+			publicKeyHash, _ := addressParam(address, i+1)
+
+			result := AddressUTXOs{Address: address, UTXOs: []UTXOView{}}
+			for _, coin := range coins[string(publicKeyHash)] {
+				result.Balance += coin.Value
+				result.UTXOs = append(result.UTXOs, UTXOView{hex.EncodeToString(coin.TxID), coin.Index, coin.Value, coin.Confirmations, coin.Immature})
+			}
+			results[i] = result
+		}
+
+		return results, nil
+	})
+}
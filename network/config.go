@@ -0,0 +1,177 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-blockchain/blockchain"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// the node's policy settings, read from a JSON file when the node starts and again on SIGHUP or the reloadconfig
+// RPC method, so they change without a restart that would drop the node's peers and pool
+// none of them is consensus: a node with a stricter policy relays and keeps fewer transactions, but accepts the
+// same blocks as everyone else, a file that fails to load leaves the settings as they were
+type NodeConfig struct {
+	MinRelayFee int    `json:"minRelayFee"` // the smallest fee a transaction pays to be kept in the pool and relayed
+	MaxMempool  int    `json:"maxMempool"`  // the most transactions the pool holds, 0 for no limit
+	MaxPeers    int    `json:"maxPeers"`    // the most known nodes, lowering it forgets no peer, 0 for no limit
+	LogLevel    string `json:"logLevel"`    // LogDebug, LogInfo or LogError
+}
+
+const (
+	LogDebug = "debug" // every message received and the pool after each transaction, as nodes always printed
+	LogInfo  = "info"
+	LogError = "error"
+)
+
+var logLevels = []string{LogDebug, LogInfo, LogError}
+
+var DefaultNodeConfig = NodeConfig{LogLevel: LogDebug}
+
+var nodeConfig = struct {
+	sync.RWMutex
+	path   string
+	config NodeConfig
+}{config: DefaultNodeConfig}
+
+// read the settings from a JSON file, fields left out keep their defaults
+func LoadNodeConfig(file string) (NodeConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return NodeConfig{}, err
+	}
+
+	c := DefaultNodeConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return NodeConfig{}, err
+	}
+
+	return c, c.validate()
+}
+
+func (c NodeConfig) validate() error {
+	switch {
+	case c.MinRelayFee < 0 || c.MaxMempool < 0 || c.MaxPeers < 0:
+		return errors.New("fees and limits cannot be negative")
+	case !slices.Contains(logLevels, c.LogLevel):
+		return fmt.Errorf("log level %q is not one of %v", c.LogLevel, logLevels)
+	}
+
+	return nil
+}
+
+// load the settings the node runs with from a file, which is read again whenever the node is asked to reload
+func SetConfigFile(file string) error {
+	c, err := LoadNodeConfig(file)
+	if err != nil {
+		return err
+	}
+
+	nodeConfig.Lock()
+	defer nodeConfig.Unlock()
+
+	nodeConfig.path, nodeConfig.config = file, c
+	return nil
+}
+
+// read the configuration file again and apply it, the settings stay as they were if it fails to load
+func ReloadConfig() (NodeConfig, error) {
+	nodeConfig.Lock()
+	defer nodeConfig.Unlock()
+
+	if nodeConfig.path == "" {
+		return nodeConfig.config, errors.New("the node was started without a configuration file")
+	}
+
+	c, err := LoadNodeConfig(nodeConfig.path)
+	if err != nil {
+		return nodeConfig.config, err
+	}
+
+	nodeConfig.config = c
+	return c, nil
+}
+
+func currentConfig() NodeConfig {
+	nodeConfig.RLock()
+	defer nodeConfig.RUnlock()
+
+	return nodeConfig.config
+}
+
+// print a message if the configured log level lets its level through
+func logf(level, format string, args ...any) {
+	if slices.Index(logLevels, level) >= slices.Index(logLevels, currentConfig().LogLevel) {
+		fmt.Printf(format, args...)
+	}
+}
+
+func reloadOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		reloadAndLog()
+	}
+}
+
+func reloadAndLog() {
+	c, err := ReloadConfig()
+	if err != nil {
+		logf(LogError, "Configuration not reloaded: %v\n", err)
+		return
+	}
+	logf(LogInfo, "Configuration reloaded: %+v\n", c)
+}
+
+// why the pool policy turns a transaction away, empty if it is accepted
+// transactions whose fee cannot be worked out, such as ones spending unknown outputs, are not judged by it
+// the caller holds poolMu
+func rejectedByPolicy(chain *blockchain.BlockChain, tx *blockchain.Transaction) string {
+	c := currentConfig()
+
+	_, pooled := memoryPool[hex.EncodeToString(tx.ID)]
+	if !pooled && c.MaxMempool > 0 && len(memoryPool) >= c.MaxMempool {
+		return fmt.Sprintf("the pool is full with %d transactions", len(memoryPool))
+	}
+	if fee, err := chain.TransactionFee(tx, memoryPool); err == nil && fee < c.MinRelayFee {
+		return fmt.Sprintf("its fee of %d is below the minimum relay fee of %d", fee, c.MinRelayFee)
+	}
+
+	return ""
+}
+
+// remember a peer unless it is known already or the peer limit is reached
+func addKnownNode(addr string) {
+	if NodeIsKnown(addr) {
+		return
+	}
+	if limit := currentConfig().MaxPeers; limit > 0 && len(KnownNodes) >= limit {
+		logf(LogInfo, "Not adding node %s, the limit of %d peers is reached\n", addr, limit)
+		return
+	}
+
+	KnownNodes = append(KnownNodes, addr)
+}
+
+func init() {
+	registerRPC("getconfig", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		return currentConfig(), nil
+	})
+
+	// an admin method like every other one, the RPC interface is only meant to be reachable by the node's operator
+	registerRPC("reloadconfig", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		c, err := ReloadConfig()
+		if err != nil {
+			return nil, err
+		}
+		logf(LogInfo, "Configuration reloaded: %+v\n", c)
+		return c, nil
+	})
+}
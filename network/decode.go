@@ -0,0 +1,145 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"golang-blockchain/blockchain"
+)
+
+// the JSON form of blocks and transactions returned by the RPC interface, sizes and weights included
+type TransactionView struct {
+	TxID        string       `json:"txid"`
+	Size        int          `json:"size"`
+	BaseSize    int          `json:"basesize"`
+	Weight      int          `json:"weight"`
+	VirtualSize int          `json:"vsize"`
+	Inputs      []InputView  `json:"vin"`
+	Outputs     []OutputView `json:"vout"`
+}
+
+type InputView struct {
+	TxID      string `json:"txid,omitempty"`
+	Output    int    `json:"vout"`
+	Signature string `json:"signature,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Coinbase  string `json:"coinbase,omitempty"` // the data of a coinbase input, hex encoded
+}
+
+type OutputView struct {
+	Value         int    `json:"value"`
+	Address       string `json:"address,omitempty"`
+	PublicKeyHash string `json:"publicKeyHash"`
+	Data          bool   `json:"data,omitempty"`
+}
+
+type BlockView struct {
+	Hash         string            `json:"hash"`
+	Height       int               `json:"height"`
+	PrevHash     string            `json:"previousblockhash,omitempty"`
+	Timestamp    int64             `json:"time"`
+	Nonce        int               `json:"nonce"`
+	MerkleRoot   string            `json:"merkleroot"`
+	Size         int               `json:"size"`
+	BaseSize     int               `json:"basesize"`
+	Weight       int               `json:"weight"`
+	VirtualSize  int               `json:"vsize"`
+	TxIDs        []string          `json:"tx,omitempty"`
+	Transactions []TransactionView `json:"transactions,omitempty"` // only when asked to be verbose
+}
+
+// addresses are encoded the way the network given by params encodes them
+func NewTransactionView(tx *blockchain.Transaction, params blockchain.Params) TransactionView {
+	view := TransactionView{
+		TxID:        hex.EncodeToString(tx.ID),
+		Size:        tx.Size(),
+		BaseSize:    tx.BaseSize(),
+		Weight:      tx.Weight(),
+		VirtualSize: tx.VirtualSize(),
+	}
+
+	for _, in := range tx.Inputs {
+		if len(in.ID) == 0 && in.Output == -1 {
+			view.Inputs = append(view.Inputs, InputView{Output: in.Output, Coinbase: hex.EncodeToString(in.PublicKey)})
+			continue
+		}
+		view.Inputs = append(view.Inputs, InputView{
+			TxID:      hex.EncodeToString(in.ID),
+			Output:    in.Output,
+			Signature: hex.EncodeToString(in.Signature),
+			PublicKey: hex.EncodeToString(in.PublicKey),
+		})
+	}
+
+	for _, out := range tx.Outputs {
+		output := OutputView{Value: out.Value, PublicKeyHash: hex.EncodeToString(out.PublicKeyHash), Data: out.IsData()}
+		if !output.Data {
+			output.Address = params.EncodeAddress(out.PublicKeyHash)
+		}
+		view.Outputs = append(view.Outputs, output)
+	}
+
+	return view
+}
+
+func NewBlockView(block *blockchain.Block, params blockchain.Params, verbose bool) BlockView {
+	view := BlockView{
+		Hash:        hex.EncodeToString(block.Hash),
+		Height:      block.Height,
+		PrevHash:    hex.EncodeToString(block.PrevHash),
+		Timestamp:   block.Timestamp,
+		Nonce:       block.Nonce,
+		MerkleRoot:  hex.EncodeToString(block.HashTransactions()),
+		Size:        block.Size(),
+		BaseSize:    block.BaseSize(),
+		Weight:      block.Weight(),
+		VirtualSize: block.VirtualSize(),
+	}
+
+	for _, tx := range block.Transactions {
+		if verbose {
+			view.Transactions = append(view.Transactions, NewTransactionView(tx, params))
+		} else {
+			view.TxIDs = append(view.TxIDs, hex.EncodeToString(tx.ID))
+		}
+	}
+
+	return view
+}
+
+func init() {
+	registerRPC("decoderawtransaction", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var data string
+		if err := parseParams(params, &data); err != nil {
+			return nil, err
+		}
+
+		raw, err := hex.DecodeString(data)
+		if err != nil || len(raw) == 0 {
+			return nil, &RPCError{RPCErrorInvalidParams, "a hex encoded transaction is expected"}
+		}
+
+		tx := blockchain.DeserializeTransaction(raw)
+		return NewTransactionView(&tx, chain.Params), nil
+	})
+
+	registerRPC("getblock", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var hash string
+		var verbose bool
+		if err := parseParams(params, &hash, &verbose); err != nil {
+			return nil, err
+		}
+
+		id, err := hex.DecodeString(hash)
+		if err != nil || len(id) == 0 {
+			return nil, &RPCError{RPCErrorInvalidParams, "a hex encoded block hash is expected"}
+		}
+
+		block, err := chain.GetBlock(id)
+		if err != nil {
+			return nil, errors.New("block not found")
+		}
+
+		return NewBlockView(&block, chain.Params, verbose), nil
+	})
+}
@@ -8,9 +8,11 @@ import (
 	"golang-blockchain/blockchain"
 	"io"
 	"log"
+	"maps"
 	"net"
 	"os"
 	"runtime"
+	"sync"
 	"syscall"
 
 	"slices"
@@ -26,11 +28,16 @@ const (
 
 var (
 	nodeAddress     string
+	serverNodeID    string // the NODE_ID the running node was started with
+	advertised      string // the address peers should use to reach the node, e.g. its onion service
 	minerAddress    string
 	knownAddress    string
 	KnownNodes      = []string{"localhost:3001"}
 	blocksInTransit = [][]byte{}
 	memoryPool      = make(map[string]blockchain.Transaction)
+
+	// guards memoryPool, which the connection handlers, the rebroadcaster and the RPC server use concurrently
+	poolMu sync.RWMutex
 )
 
 type Address struct {
@@ -138,7 +145,7 @@ func SendInventory(addr, kind string, items [][]byte) {
 func SendTransaction(addr string, tx *blockchain.Transaction) {
 	data := Transaction{AddressFrom: nodeAddress, Transaction: tx.Serialize()}
 	payload := GobEncode(data)
-	request := append(CmdToBytes("inv"), payload...)
+	request := append(CmdToBytes("tx"), payload...)
 
 	SendData(addr, request)
 }
@@ -169,9 +176,9 @@ func SendGetData(addr, kind string, id []byte) {
 }
 
 func SendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
+	conn, err := dial(addr)
 	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
+		fmt.Printf("%s is not available: %s\n", addr, err)
 		var updatedNodes []string
 
 		for _, node := range KnownNodes {
@@ -203,7 +210,9 @@ func HandleAddress(request []byte) {
 		log.Panic(err)
 	}
 
-	KnownNodes = append(KnownNodes, payload.AddressList...)
+	for _, addr := range payload.AddressList {
+		addKnownNode(addr)
+	}
 	fmt.Printf("There are %d known nodes\n", len(KnownNodes))
 	RequestBlocks()
 }
@@ -223,7 +232,16 @@ func HandleBlock(request []byte, chain *blockchain.BlockChain) {
 	block := blockchain.Deserialize(blockData)
 
 	fmt.Printf("Received a new block!\n")
-	chain.AddBlock(block)
+	if err := blockchain.CheckBlock(block, chain.Params.Difficulty); err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+		return
+	}
+
+	// the blocks the main chain gains are announced by a connect hook, including the stored blocks connected with it
+	if err := chain.AddBlock(block); err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+		return
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
 
@@ -232,9 +250,6 @@ func HandleBlock(request []byte, chain *blockchain.BlockChain) {
 		SendGetData(payload.AddressFrom, "block", blockHash)
 
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-		UTXOSet.Reindex()
 	}
 }
 
@@ -275,7 +290,9 @@ func HandleGetData(request []byte, chain *blockchain.BlockChain) {
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
+		poolMu.RLock()
 		tx := memoryPool[txID]
+		poolMu.RUnlock()
 
 		SendTransaction(payload.AddressFrom, &tx)
 	}
@@ -293,9 +310,16 @@ func HandleTx(request []byte, chain *blockchain.BlockChain) {
 
 	txData := payload.Transaction
 	tx := blockchain.DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
+	if reason := acceptTransaction(chain, &tx); reason != "" {
+		logf(LogInfo, "Not relaying transaction %x: %s\n", tx.ID, reason)
+		return
+	}
+	publishTransaction(&tx)
 
-	fmt.Printf("%s, %d\n", nodeAddress, len(memoryPool))
+	logf(LogDebug, "%s, %d\n", nodeAddress, poolSize())
+	for _, entry := range MempoolInfo(chain) {
+		logf(LogDebug, "%s\n", entry)
+	}
 
 	if nodeAddress == KnownNodes[0] {
 		for _, node := range KnownNodes {
@@ -304,41 +328,87 @@ func HandleTx(request []byte, chain *blockchain.BlockChain) {
 			}
 		}
 	} else {
-		if len(memoryPool) >= 2 && len(minerAddress) > 0 {
+		if poolSize() >= 2 && len(minerAddress) > 0 {
 			MineTx(chain)
 		}
 	}
 }
 
-func MineTx(chain *blockchain.BlockChain) {
-	var txs []*blockchain.Transaction
+// add a transaction to the pool if it verifies against the chain and the pool and the relay policy lets it in,
+// returning why it was turned away otherwise
+// every transaction entering the pool goes through here, whether it came from a peer or is rebroadcast
+func acceptTransaction(chain *blockchain.BlockChain, tx *blockchain.Transaction) string {
+	poolMu.Lock()
+	defer poolMu.Unlock()
 
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].ID)
-		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
+	if !chain.VerifyPoolTransaction(tx, memoryPool) {
+		return "it does not verify"
+	}
+	if reason := rejectedByPolicy(chain, tx); reason != "" {
+		return reason
 	}
 
+	memoryPool[hex.EncodeToString(tx.ID)] = *tx
+	return ""
+}
+
+func poolSize() int {
+	poolMu.RLock()
+	defer poolMu.RUnlock()
+
+	return len(memoryPool)
+}
+
+// a copy of the pool, for work too slow to hold the lock through such as mining
+func poolSnapshot() map[string]blockchain.Transaction {
+	poolMu.RLock()
+	defer poolMu.RUnlock()
+
+	return maps.Clone(memoryPool)
+}
+
+// describe the transactions waiting in the pool, ranked by the fee rate of their ancestor packages
+func MempoolInfo(chain *blockchain.BlockChain) []blockchain.MempoolEntry {
+	poolMu.RLock()
+	defer poolMu.RUnlock()
+
+	return chain.MempoolEntries(memoryPool)
+}
+
+func MineTx(chain *blockchain.BlockChain) {
+	// children paying for their parents are picked together with them, parents first
+	// the block size and the minimum fee can be changed by governance proposals
+	maxSize := chain.Parameter(blockchain.ParamMaxBlockSize, chain.Params.MaxBlockSize)
+	minFee := chain.Parameter(blockchain.ParamMinFee, chain.Params.MinFee)
+	pool := poolSnapshot()
+	txs := chain.SelectTransactions(pool, maxSize, minFee)
+
 	if len(txs) == 0 {
 		fmt.Println("All Transactions are invalid")
 		return
 	}
 
-	cbTx := blockchain.CoinbaseTx(minerAddress, "")
+	fees := 0
+	for _, tx := range txs {
+		fmt.Printf("tx: %x\n", tx.ID)
+		fee, err := chain.TransactionFee(tx, pool)
+		blockchain.Handle(err)
+		fees += fee
+	}
+
+	cbTx := chain.NewCoinbaseTx(minerAddress, "", fees)
 	txs = append(txs, cbTx)
 
 	newBlock := chain.MineBlock(txs)
-	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
 
 	fmt.Println("New Block mined")
 
+	poolMu.Lock()
 	for _, tx := range txs {
 		txID := hex.EncodeToString(tx.ID)
 		delete(memoryPool, txID)
 	}
+	poolMu.Unlock()
 
 	for _, node := range KnownNodes {
 		if node != nodeAddress {
@@ -346,7 +416,7 @@ func MineTx(chain *blockchain.BlockChain) {
 		}
 	}
 
-	if len(memoryPool) > 0 {
+	if poolSize() > 0 {
 		MineTx(chain)
 	}
 }
@@ -365,7 +435,7 @@ func HandleVersion(request []byte, chain *blockchain.BlockChain) {
 	bestHeight := chain.GetBestHeight()
 	otherHeight := payload.BestHeight
 
-	fmt.Println("bestHeight", bestHeight, "otherHeight", otherHeight)
+	logf(LogDebug, "bestHeight %d otherHeight %d\n", bestHeight, otherHeight)
 
 	if bestHeight < otherHeight {
 		SendGetBlocks(payload.AddressFrom)
@@ -373,9 +443,7 @@ func HandleVersion(request []byte, chain *blockchain.BlockChain) {
 		SendVersion(payload.AddressFrom, chain)
 	}
 
-	if !NodeIsKnown(payload.AddressFrom) {
-		KnownNodes = append(KnownNodes, payload.AddressFrom)
-	}
+	addKnownNode(payload.AddressFrom)
 }
 
 func HandleInv(request []byte, chain *blockchain.BlockChain) {
@@ -409,7 +477,11 @@ func HandleInv(request []byte, chain *blockchain.BlockChain) {
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
+		poolMu.RLock()
+		_, pooled := memoryPool[hex.EncodeToString(txID)]
+		poolMu.RUnlock()
+
+		if !pooled {
 			SendGetData(payload.AddressFrom, "tx", txID)
 		}
 	}
@@ -424,7 +496,7 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 	}
 
 	command := BytesToCmd(req[:commandLength])
-	fmt.Printf("Received %s command\n", command)
+	logf(LogDebug, "Received %s command\n", command)
 
 	switch command {
 	case "addr":
@@ -446,21 +518,57 @@ func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
 	}
 }
 
+// announce the node to its peers under another address than the one it listens on
+// a node running as an onion service listens locally and is reached through its .onion address
+func SetAdvertisedAddress(addr string) {
+	advertised = addr
+}
+
 func StartServer(nodeID, mAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+	listenAddress := fmt.Sprintf("localhost:%s", nodeID)
+	nodeAddress = listenAddress
+	serverNodeID = nodeID
+	if advertised != "" {
+		nodeAddress = advertised
+	}
 	minerAddress = mAddress
 
-	ln, err := net.Listen(protocol, nodeAddress)
+	ln, err := net.Listen(protocol, listenAddress)
 
 	if err != nil {
 		log.Panic(err)
 	}
 	defer ln.Close()
 
+	if publisherAddress != "" {
+		publisher, err = StartPublisher(publisherAddress)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer publisher.Close()
+		fmt.Printf("Publishing blocks and transactions on %s\n", publisherAddress)
+	}
+
 	chain := blockchain.ContinueBlockChain(nodeID)
-	defer chain.Database.Close()
+	defer chain.Close()
 	go CloseDB(chain)
 
+	broadcasts, err = LoadBroadcasts(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	go runRebroadcaster(chain)
+	go reloadOnHangup()
+
+	if rpcAddress != "" {
+		server, err := StartRPCServer(rpcAddress, chain)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer server.Close()
+		fmt.Printf("Serving JSON-RPC on %s\n", rpcAddress)
+	}
+
 	if nodeAddress != KnownNodes[0] {
 		SendVersion(KnownNodes[0], chain)
 	}
@@ -496,6 +604,6 @@ func CloseDB(chain *blockchain.BlockChain) {
 	d.WaitForDeathWithFunc(func() {
 		defer os.Exit(1)
 		defer runtime.Goexit()
-		chain.Database.Close()
+		chain.Close()
 	})
 }
@@ -0,0 +1,266 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang-blockchain/blockchain"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// a publisher socket in the spirit of bitcoind's ZeroMQ interface, so indexers can follow the node without polling
+// subscribers connect over TCP and send one topic prefix per line, an empty line subscribes to every topic
+// every event is then written as three frames, each preceded by its big endian uint32 length:
+// the topic, the body (a serialized block or transaction, or a hash) and a little endian uint32 sequence
+// number counting the events of that topic, so subscribers can tell when they missed some
+const (
+	TopicRawBlock  = "rawblock"
+	TopicRawTx     = "rawtx"
+	TopicHashBlock = "hashblock"
+	TopicHashTx    = "hashtx"
+
+	// events queued for a subscriber before newer ones are dropped, like a ZeroMQ high-water mark
+	subscriberQueue = 1000
+	maxFrameLength  = 64 << 20
+)
+
+var (
+	publisher        *Publisher
+	publisherAddress string
+)
+
+type Publisher struct {
+	ln          net.Listener
+	mu          sync.Mutex
+	subscribers map[*subscriber]bool
+	sequences   map[string]uint32
+}
+
+type subscriber struct {
+	conn     net.Conn
+	mu       sync.Mutex
+	prefixes []string
+	events   chan []byte
+}
+
+// an event received by Subscribe
+type Event struct {
+	Topic    string
+	Body     []byte
+	Sequence uint32
+}
+
+// publish events on the given address once the node starts, an empty address disables publishing
+func SetPublisherAddress(addr string) {
+	publisherAddress = addr
+}
+
+func StartPublisher(addr string) (*Publisher, error) {
+	ln, err := net.Listen(protocol, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Publisher{ln: ln, subscribers: make(map[*subscriber]bool), sequences: make(map[string]uint32)}
+	go p.accept()
+
+	return p, nil
+}
+
+func (p *Publisher) accept() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s := &subscriber{conn: conn, events: make(chan []byte, subscriberQueue)}
+
+		p.mu.Lock()
+		p.subscribers[s] = true
+		p.mu.Unlock()
+
+		go p.readSubscriptions(s)
+		go p.write(s)
+	}
+}
+
+// every line a subscriber sends adds a topic prefix, the connection closing unsubscribes it
+func (p *Publisher) readSubscriptions(s *subscriber) {
+	scanner := bufio.NewScanner(s.conn)
+	for scanner.Scan() {
+		s.mu.Lock()
+		s.prefixes = append(s.prefixes, strings.TrimSpace(scanner.Text()))
+		s.mu.Unlock()
+	}
+
+	p.drop(s)
+}
+
+func (p *Publisher) write(s *subscriber) {
+	for event := range s.events {
+		if _, err := s.conn.Write(event); err != nil {
+			p.drop(s)
+			return
+		}
+	}
+}
+
+func (p *Publisher) drop(s *subscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.subscribers[s] {
+		delete(p.subscribers, s)
+		close(s.events)
+		s.conn.Close()
+	}
+}
+
+func (s *subscriber) wants(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendFrame(message, frame []byte) []byte {
+	message = binary.BigEndian.AppendUint32(message, uint32(len(frame)))
+	return append(message, frame...)
+}
+
+// send an event to every subscriber of its topic, never blocking on a slow one
+func (p *Publisher) Publish(topic string, body []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sequence := p.sequences[topic]
+	p.sequences[topic]++
+
+	message := appendFrame(nil, []byte(topic))
+	message = appendFrame(message, body)
+	message = appendFrame(message, binary.LittleEndian.AppendUint32(nil, sequence))
+
+	for s := range p.subscribers {
+		if !s.wants(topic) {
+			continue
+		}
+
+		select {
+		case s.events <- message:
+		default:
+			log.Printf("subscriber %s is too slow, dropped a %s event", s.conn.RemoteAddr(), topic)
+		}
+	}
+}
+
+func (p *Publisher) Close() error {
+	err := p.ln.Close()
+
+	p.mu.Lock()
+	subscribers := make([]*subscriber, 0, len(p.subscribers))
+	for s := range p.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	p.mu.Unlock()
+
+	for _, s := range subscribers {
+		p.drop(s)
+	}
+
+	return err
+}
+
+// announce a transaction accepted into the memory pool or a block
+func publishTransaction(tx *blockchain.Transaction) {
+	if publisher == nil {
+		return
+	}
+
+	publisher.Publish(TopicHashTx, tx.ID)
+	publisher.Publish(TopicRawTx, tx.Serialize())
+}
+
+func init() {
+	// every block the main chain gains is announced once, whether it was mined here, received or connected by a
+	// reorganization
+	blockchain.RegisterConnectHook(func(chainID string, block *blockchain.Block) {
+		publishBlock(block)
+	})
+}
+
+// announce a block that became part of the main chain, followed by its transactions
+func publishBlock(block *blockchain.Block) {
+	if publisher == nil {
+		return
+	}
+
+	publisher.Publish(TopicHashBlock, block.Hash)
+	publisher.Publish(TopicRawBlock, block.Serialize())
+
+	for _, tx := range block.Transactions {
+		publishTransaction(tx)
+	}
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxFrameLength {
+		return nil, fmt.Errorf("frame of %d bytes is too large", n)
+	}
+
+	frame := make([]byte, n)
+	_, err := io.ReadFull(r, frame)
+	return frame, err
+}
+
+// connect to a publisher and hand every event of the given topics to the handler until the connection ends
+// no topics subscribes to everything
+func Subscribe(addr string, topics []string, handler func(Event)) error {
+	conn, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if len(topics) == 0 {
+		topics = []string{""}
+	}
+	if _, err := io.WriteString(conn, strings.Join(topics, "\n")+"\n"); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		var frames [3][]byte
+		for i := range frames {
+			frames[i], err = readFrame(reader)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		if len(frames[2]) != 4 {
+			return errors.New("malformed sequence frame")
+		}
+		handler(Event{string(frames[0]), frames[1], binary.LittleEndian.Uint32(frames[2])})
+	}
+}
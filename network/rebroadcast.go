@@ -0,0 +1,280 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-blockchain/blockchain"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the wallet side of broadcasting: every transaction the node's wallets send is tracked in a file next to
+// the wallets, and the node keeps broadcasting it until it is mined, it can no longer be mined because an
+// output it spends was spent by another transaction, or it has not been mined for AbandonAfter
+const (
+	broadcastFile = "./tmp/broadcasts_%s.data"
+
+	BroadcastPending   = "pending"
+	BroadcastConfirmed = "confirmed"
+	BroadcastAbandoned = "abandoned"
+
+	RebroadcastInterval = time.Minute
+	AbandonAfter        = 24 * time.Hour
+)
+
+type BroadcastRecord struct {
+	ID            string `json:"id"`
+	Chain         string `json:"chain"`
+	Transaction   string `json:"transaction"` // the serialized transaction, hex encoded
+	Status        string `json:"status"`
+	Reason        string `json:"reason,omitempty"` // why the transaction was abandoned
+	Created       int64  `json:"created"`
+	LastBroadcast int64  `json:"lastBroadcast"`
+	Attempts      int    `json:"attempts"`
+	Height        int    `json:"height,omitempty"` // the height of the block confirming the transaction
+	Confirmations int    `json:"confirmations,omitempty"`
+}
+
+type Broadcasts struct {
+	mu      sync.Mutex
+	nodeID  string
+	Records map[string]*BroadcastRecord `json:"records"`
+}
+
+var broadcasts *Broadcasts
+
+func LoadBroadcasts(nodeID string) (*Broadcasts, error) {
+	b := Broadcasts{nodeID: nodeID, Records: make(map[string]*BroadcastRecord)}
+
+	data, err := os.ReadFile(fmt.Sprintf(broadcastFile, nodeID))
+	if os.IsNotExist(err) {
+		return &b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	if b.Records == nil {
+		b.Records = make(map[string]*BroadcastRecord)
+	}
+
+	return &b, nil
+}
+
+func (b *Broadcasts) Save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fmt.Sprintf(broadcastFile, b.nodeID), data, 0644)
+}
+
+// start tracking a transaction that was just broadcast for the first time
+func (b *Broadcasts) Track(tx *blockchain.Transaction, chainID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now().Unix()
+	id := hex.EncodeToString(tx.ID)
+	b.Records[id] = &BroadcastRecord{
+		ID:            id,
+		Chain:         chainID,
+		Transaction:   hex.EncodeToString(tx.Serialize()),
+		Status:        BroadcastPending,
+		Created:       now,
+		LastBroadcast: now,
+		Attempts:      1,
+	}
+}
+
+func (b *Broadcasts) Status(id string) (BroadcastRecord, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	record, ok := b.Records[strings.ToLower(id)]
+	if !ok {
+		return BroadcastRecord{}, false
+	}
+	return *record, true
+}
+
+// every tracked transaction, oldest first
+func (b *Broadcasts) List() []BroadcastRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []BroadcastRecord
+	for _, record := range b.Records {
+		records = append(records, *record)
+	}
+	slices.SortFunc(records, func(x, y BroadcastRecord) int {
+		if x.Created != y.Created {
+			return int(x.Created - y.Created)
+		}
+		return strings.Compare(x.ID, y.ID)
+	})
+
+	return records
+}
+
+// bring the status of the chain's records up to date and return the pending transactions due for a rebroadcast
+// confirmed transactions go back to pending when a reorganization takes their block off the main chain
+func (b *Broadcasts) refresh(chain *blockchain.BlockChain, pool map[string]blockchain.Transaction, now time.Time) []*blockchain.Transaction {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	height := chain.GetStats().Height
+
+	txs := make(map[string]blockchain.Transaction)
+	for id, record := range b.Records {
+		if record.Chain != chain.ChainID {
+			continue
+		}
+
+		raw, err := hex.DecodeString(record.Transaction)
+		if err != nil {
+			record.Status, record.Reason = BroadcastAbandoned, "the recorded transaction is corrupt"
+			continue
+		}
+		txs[id] = blockchain.DeserializeTransaction(raw)
+	}
+
+	// the wallets may spend the change of their own transactions before those are mined
+	available := maps.Clone(pool)
+	for id, tx := range txs {
+		if b.Records[id].Status != BroadcastAbandoned {
+			available[id] = tx
+		}
+	}
+
+	var due []*blockchain.Transaction
+	for id, tx := range txs {
+		record := b.Records[id]
+
+		if confirmedAt, ok := chain.ConfirmedHeight(&tx); ok {
+			record.Status, record.Reason = BroadcastConfirmed, ""
+			record.Height, record.Confirmations = confirmedAt, height-confirmedAt+1
+			continue
+		}
+		if record.Status == BroadcastAbandoned {
+			continue
+		}
+		record.Status, record.Height, record.Confirmations = BroadcastPending, 0, 0
+
+		switch {
+		case chain.Conflicted(&tx, available):
+			record.Status, record.Reason = BroadcastAbandoned, "conflicted: an output it spends was spent by another transaction"
+		case now.Sub(time.Unix(record.Created, 0)) > AbandonAfter:
+			record.Status, record.Reason = BroadcastAbandoned, fmt.Sprintf("dropped: not mined within %s", AbandonAfter)
+		case now.Sub(time.Unix(record.LastBroadcast, 0)) >= RebroadcastInterval:
+			record.LastBroadcast = now.Unix()
+			record.Attempts++
+			due = append(due, &tx)
+		}
+	}
+
+	return due
+}
+
+// turn the records confirmed by a disconnected block back into pending ones, due for a rebroadcast right away
+func (b *Broadcasts) unconfirm(chainID string, block *blockchain.Block) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changed := false
+	for _, tx := range block.Transactions {
+		record, ok := b.Records[hex.EncodeToString(tx.ID)]
+		if !ok || record.Chain != chainID || record.Status != BroadcastConfirmed {
+			continue
+		}
+
+		record.Status, record.Height, record.Confirmations = BroadcastPending, 0, 0
+		record.LastBroadcast = 0
+		changed = true
+	}
+
+	return changed
+}
+
+// send a transaction created by the node's wallets and keep track of it until it is mined
+func BroadcastTransaction(nodeID string, chain *blockchain.BlockChain, tx *blockchain.Transaction) error {
+	SendTransaction(KnownNodes[0], tx)
+
+	b, err := LoadBroadcasts(nodeID)
+	if err != nil {
+		return err
+	}
+	b.Track(tx, chain.ChainID)
+
+	return b.Save()
+}
+
+// periodically rebroadcast the pending transactions of the node's wallets, starting as soon as the node does
+func runRebroadcaster(chain *blockchain.BlockChain) {
+	for ; ; time.Sleep(RebroadcastInterval) {
+		for _, tx := range broadcasts.refresh(chain, poolSnapshot(), time.Now()) {
+			// a node mining itself picks its own transactions up again, if they still pass the pool's checks
+			if reason := acceptTransaction(chain, tx); reason != "" {
+				logf(LogInfo, "Not rebroadcasting transaction %x: %s\n", tx.ID, reason)
+				continue
+			}
+
+			fmt.Printf("Rebroadcasting transaction %x\n", tx.ID)
+			for _, node := range KnownNodes {
+				if node != nodeAddress {
+					SendTransaction(node, tx)
+				}
+			}
+		}
+
+		if err := broadcasts.Save(); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func init() {
+	blockchain.RegisterDisconnectHook(func(chainID string, block *blockchain.Block) {
+		if broadcasts == nil || !broadcasts.unconfirm(chainID, block) {
+			return
+		}
+		fmt.Printf("Block %x was disconnected, its transactions are unconfirmed again\n", block.Hash)
+
+		if err := broadcasts.Save(); err != nil {
+			fmt.Println(err)
+		}
+	})
+
+	registerRPC("listbroadcasts", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		return broadcasts.List(), nil
+	})
+
+	registerRPC("gettransactionstatus", func(chain *blockchain.BlockChain, params json.RawMessage) (any, error) {
+		var id string
+		if err := parseParams(params, &id); err != nil {
+			return nil, err
+		}
+		if id == "" {
+			return nil, &RPCError{RPCErrorInvalidParams, "a transaction ID is expected"}
+		}
+
+		record, ok := broadcasts.Status(id)
+		if !ok {
+			return nil, errors.New("transaction is not tracked")
+		}
+		return record, nil
+	})
+}
@@ -0,0 +1,254 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-blockchain/blockchain"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// a JSON-RPC 2.0 interface over HTTP, every request is POSTed to / and params are given by position
+// methods register themselves in rpcMethods, next to the code they expose
+// several requests can be POSTed as an array and are answered by an array of responses in the same order
+const (
+	maxRPCRequest = 1 << 20
+	maxRPCBatch   = 1000 // the most requests a single batch may hold
+)
+
+// the standard JSON-RPC error codes, failures of the method itself use RPCErrorMethod
+const (
+	RPCErrorParse          = -32700
+	RPCErrorInvalidRequest = -32600
+	RPCErrorNotFound       = -32601
+	RPCErrorInvalidParams  = -32602
+	RPCErrorMethod         = -32000
+)
+
+type rpcMethod func(chain *blockchain.BlockChain, params json.RawMessage) (any, error)
+
+var (
+	rpcMethods = make(map[string]rpcMethod)
+	rpcAddress string
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func registerRPC(name string, method rpcMethod) {
+	rpcMethods[name] = method
+}
+
+// serve RPC requests on the given address once the node starts, an empty address disables the interface
+func SetRPCAddress(addr string) {
+	rpcAddress = addr
+}
+
+func StartRPCServer(addr string, chain *blockchain.BlockChain) (*http.Server, error) {
+	ln, err := net.Listen(protocol, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: rpcHandler(chain), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Println(err)
+		}
+	}()
+
+	return server, nil
+}
+
+func rpcHandler(chain *blockchain.BlockChain) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "JSON-RPC requests are POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRPCRequest))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		body = bytes.TrimSpace(body)
+		if len(body) == 0 || body[0] != '[' {
+			json.NewEncoder(w).Encode(handleRawRPC(chain, body))
+			return
+		}
+
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			json.NewEncoder(w).Encode(finishResponse(rpcResponse{Error: &RPCError{RPCErrorParse, err.Error()}}))
+			return
+		}
+		if len(batch) == 0 || len(batch) > maxRPCBatch {
+			message := fmt.Sprintf("a batch holds between 1 and %d requests", maxRPCBatch)
+			json.NewEncoder(w).Encode(finishResponse(rpcResponse{Error: &RPCError{RPCErrorInvalidRequest, message}}))
+			return
+		}
+
+		responses := make([]rpcResponse, len(batch))
+		for i, raw := range batch {
+			responses[i] = handleRawRPC(chain, raw)
+		}
+		json.NewEncoder(w).Encode(responses)
+	})
+}
+
+// answer a single encoded request, a request that cannot be decoded is answered with an error
+func handleRawRPC(chain *blockchain.BlockChain, raw json.RawMessage) rpcResponse {
+	var request rpcRequest
+	if err := json.Unmarshal(raw, &request); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return finishResponse(rpcResponse{Error: &RPCError{RPCErrorParse, err.Error()}})
+		}
+		return finishResponse(rpcResponse{Error: &RPCError{RPCErrorInvalidRequest, err.Error()}})
+	}
+
+	return finishResponse(handleRPC(chain, request))
+}
+
+func finishResponse(response rpcResponse) rpcResponse {
+	response.JSONRPC = "2.0"
+	if response.ID == nil {
+		response.ID = json.RawMessage("null")
+	}
+	return response
+}
+
+func handleRPC(chain *blockchain.BlockChain, request rpcRequest) (response rpcResponse) {
+	response.ID = request.ID
+
+	// the chain panics on the errors it cannot recover from, such as undecodable data, which must not take the node down
+	defer func() {
+		if r := recover(); r != nil {
+			response.Result = nil
+			response.Error = &RPCError{RPCErrorMethod, fmt.Sprint(r)}
+		}
+	}()
+
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		response.Error = &RPCError{RPCErrorInvalidRequest, "not a JSON-RPC 2.0 request"}
+		return response
+	}
+
+	method, ok := rpcMethods[request.Method]
+	if !ok {
+		response.Error = &RPCError{RPCErrorNotFound, fmt.Sprintf("method %s does not exist", request.Method)}
+		return response
+	}
+
+	result, err := method(chain, request.Params)
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{RPCErrorMethod, err.Error()}
+		}
+		response.Error = rpcErr
+		return response
+	}
+
+	response.Result = result
+	return response
+}
+
+// decode positional params into the targets, trailing params may be left out
+func parseParams(params json.RawMessage, targets ...any) error {
+	if len(bytes.TrimSpace(params)) == 0 {
+		return nil
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(params, &values); err != nil {
+		return &RPCError{RPCErrorInvalidParams, "params must be an array"}
+	}
+	if len(values) > len(targets) {
+		return &RPCError{RPCErrorInvalidParams, fmt.Sprintf("at most %d params are expected", len(targets))}
+	}
+
+	for i, value := range values {
+		if err := json.Unmarshal(value, targets[i]); err != nil {
+			return &RPCError{RPCErrorInvalidParams, fmt.Sprintf("param %d: %v", i+1, err)}
+		}
+	}
+
+	return nil
+}
+
+// call a method of a node's RPC interface, the connection goes through the proxy when one is set
+func CallRPC(addr, method string, params []any) (json.RawMessage, error) {
+	if params == nil {
+		params = []any{}
+	}
+
+	encodedParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: encodedParams})
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(addr)
+		},
+	}}
+
+	resp, err := client.Post("http://"+addr+"/", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpc request failed: %s", resp.Status)
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, response.Error
+	}
+
+	return response.Result, nil
+}
@@ -16,7 +16,7 @@ import (
 
 const (
 	checksumLength = 4
-	version        = byte(0x00)
+	version        = byte(0x00) // the version of main chain addresses, other networks bring their own
 )
 
 type Wallet struct {
@@ -83,8 +83,16 @@ func (w *Wallet) UnmarshalJSON(data []byte) error {
 }
 
 func (w Wallet) Address() []byte {
-	publicKeyHashed := PublicKeyHash(w.PublicKey)
+	return EncodeAddress(PublicKeyHash(w.PublicKey))
+}
+
+// build the address of a public key hash, the inverse of stripping an address down to its hash
+func EncodeAddress(publicKeyHashed []byte) []byte {
+	return EncodeAddressVersion(publicKeyHashed, version)
+}
 
+// build the address of a public key hash for a network using another version byte
+func EncodeAddressVersion(publicKeyHashed []byte, version byte) []byte {
 	versionedHash := append([]byte{version}, publicKeyHashed...)
 	checksum := generateChecksum(versionedHash)
 
@@ -5,17 +5,23 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 )
 
-const walletFile = "./tmp/wallets_%s.data"
+const (
+	walletFile    = "./tmp/wallets_%s.data"
+	bundleVersion = 1
+)
 
 type Wallets struct {
 	Wallets map[string]*Wallet `json:"wallets"`
+	Frozen  map[string]bool    `json:"frozen,omitempty"` // TXID:INDEX of the coins kept out of automatic coin selection
 }
 
 func CreateWallets(nodeId string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Frozen = make(map[string]bool)
 
 	err := wallets.loadFile(nodeId)
 	return &wallets, err
@@ -63,9 +69,21 @@ func (wallets *Wallets) loadFile(nodeId string) error {
 	}
 
 	wallets.Wallets = ws.Wallets
+	if ws.Frozen != nil {
+		wallets.Frozen = ws.Frozen
+	}
 	return nil
 }
 
+// keep a coin out of automatic coin selection, it can only be spent again once unfrozen
+func (wallets *Wallets) Freeze(outpoint string) {
+	wallets.Frozen[outpoint] = true
+}
+
+func (wallets *Wallets) Unfreeze(outpoint string) {
+	delete(wallets.Frozen, outpoint)
+}
+
 func (wallets *Wallets) SaveFile(nodeId string) {
 	walletFile := fmt.Sprintf(walletFile, nodeId)
 
@@ -79,3 +97,62 @@ func (wallets *Wallets) SaveFile(nodeId string) {
 		log.Panic(err)
 	}
 }
+
+// a portable bundle of wallets, used to move keys between nodes or keep them in a backup
+type WalletBundle struct {
+	Version int                `json:"version"`
+	Created int64              `json:"created"`
+	Wallets map[string]*Wallet `json:"wallets"`
+}
+
+// write every wallet of the node into a bundle file
+func (wallets *Wallets) Export(file string) error {
+	bundle := WalletBundle{Version: bundleVersion, Created: time.Now().Unix(), Wallets: wallets.Wallets}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the bundle holds private keys, so keep it readable by the owner only
+	return os.WriteFile(file, data, 0600)
+}
+
+// merge the wallets of a bundle file into the node's wallets, returning how many were added
+// wallets that already exist are kept untouched
+func (wallets *Wallets) Import(file string) (int, error) {
+	fileContent, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	var bundle WalletBundle
+	err = json.Unmarshal(fileContent, &bundle)
+	if err != nil {
+		return 0, err
+	}
+
+	if bundle.Version != bundleVersion {
+		return 0, fmt.Errorf("unsupported wallet bundle version %d", bundle.Version)
+	}
+
+	imported := 0
+	for address, w := range bundle.Wallets {
+		if w == nil {
+			return imported, fmt.Errorf("wallet %s is empty", address)
+		}
+		// the address must be derived from the bundled key, otherwise the bundle was tampered with
+		if fmt.Sprintf("%s", w.Address()) != address {
+			return imported, fmt.Errorf("wallet %s does not match its key", address)
+		}
+
+		if _, ok := wallets.Wallets[address]; ok {
+			continue
+		}
+
+		wallets.Wallets[address] = w
+		imported++
+	}
+
+	return imported, nil
+}